@@ -0,0 +1,84 @@
+// Package bot lets chat rooms interact with their group through commands
+// such as /matches, /stats, /newmatch and /score. Chat networks are plugged
+// in as transports; Telegram and Matrix adapters are provided.
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/p4u/padelfriends/ratelimit"
+	"github.com/p4u/padelfriends/services"
+)
+
+// Message is a text message received in a chat.
+type Message struct {
+	ChatID string
+	Sender string
+	Text   string
+}
+
+// Transport connects the bot to a chat network.
+type Transport interface {
+	// Name identifies the transport in the chat links, e.g. "telegram".
+	Name() string
+	// Receive delivers incoming messages to handle until ctx is done.
+	Receive(ctx context.Context, handle func(Message)) error
+	// Send posts a text message to a chat.
+	Send(ctx context.Context, chatID, text string) error
+}
+
+// Bot answers the commands received on its transports.
+type Bot struct {
+	Links   *services.BotService
+	Groups  *services.GroupService
+	Players *services.PlayerService
+	Matches *services.MatchService
+	Stats   *services.StatsService
+
+	// Limiter throttles the /link attempts per chat, none when nil
+	Limiter *ratelimit.Limiter
+
+	transports []Transport
+}
+
+// AddTransport registers a transport to serve.
+func (b *Bot) AddTransport(t Transport) {
+	b.transports = append(b.transports, t)
+}
+
+// Run serves every transport until ctx is done.
+func (b *Bot) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range b.transports {
+		wg.Add(1)
+		go func(t Transport) {
+			defer wg.Done()
+			err := t.Receive(ctx, func(msg Message) {
+				b.handle(ctx, t, msg)
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Error("bot: transport stopped", "transport", t.Name(), "error", err)
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// handle runs a command and replies in the same chat.
+func (b *Bot) handle(ctx context.Context, t Transport, msg Message) {
+	text := strings.TrimSpace(msg.Text)
+	if !strings.HasPrefix(text, "/") {
+		return
+	}
+
+	reply := b.Execute(ctx, t.Name(), msg.ChatID, text)
+	if reply == "" {
+		return
+	}
+	if err := t.Send(ctx, msg.ChatID, reply); err != nil {
+		slog.WarnContext(ctx, "bot: cannot reply", "transport", t.Name(), "error", err)
+	}
+}
@@ -0,0 +1,315 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/scoring"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxListedMatches bounds the results shown by /matches, and
+// maxPendingMatches the pending matches numbered for /score.
+const (
+	maxListedMatches  = 10
+	maxPendingMatches = services.MaxPageSize
+)
+
+const helpText = `Padel Friends commands:
+/link <group> <password> or /link <token> - link this chat to a group
+/unlink - unlink this chat
+/matches - pending matches and latest results
+/stats - group standings
+/newmatch A B vs C D - create a match, team A B against team C D
+/score 6-4 [3-6 6-3] - submit the sets of the oldest pending match
+/score #2 6-4 - submit the result of the second pending match of /matches`
+
+// Execute runs a command of a chat and returns the reply.
+func (b *Bot) Execute(ctx context.Context, transport, chatID, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	// Telegram appends the bot name to commands in groups: /stats@padelbot
+	cmd, _, _ := strings.Cut(strings.ToLower(fields[0]), "@")
+	args := fields[1:]
+
+	switch cmd {
+	case "/start", "/help":
+		return helpText
+	case "/link":
+		return b.link(ctx, transport, chatID, args)
+	}
+
+	groupName, err := b.Links.ChatGroup(ctx, transport, chatID)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if groupName == "" {
+		return "This chat is not linked to a group yet. Use /link <group> <password>."
+	}
+
+	switch cmd {
+	case "/unlink":
+		if err := b.Links.UnlinkChat(ctx, transport, chatID); err != nil {
+			return "Error: " + err.Error()
+		}
+		return "This chat is no longer linked to " + groupName + "."
+	case "/matches":
+		return b.matches(ctx, groupName)
+	case "/stats":
+		return b.stats(ctx, groupName)
+	case "/newmatch":
+		return b.newMatch(ctx, groupName, args)
+	case "/score":
+		return b.score(ctx, groupName, args)
+	default:
+		return "Unknown command. Send /help for the list of commands."
+	}
+}
+
+// link handles /link <group> <password> and /link <token>. The attempts are
+// throttled per chat like the API requests per client, and wrong passwords
+// or tokens lock the chat out.
+func (b *Bot) link(ctx context.Context, transport, chatID string, args []string) string {
+	if len(args) == 0 {
+		return "Usage: /link <group> <password> or /link <token>"
+	}
+	client := transport + ":" + chatID
+
+	if len(args) == 1 {
+		if wait := b.Limiter.Allow(client, ""); wait > 0 {
+			return tooManyAttempts(wait)
+		}
+		groupName, err := b.Links.LinkChatWithToken(ctx, transport, chatID, args[0])
+		if errors.Is(err, services.ErrValidation) {
+			b.Limiter.Fail(ctx, client, "")
+		}
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		return "This chat is now linked to " + groupName + "."
+	}
+
+	// The group name may contain spaces, the password is the last word
+	groupName := strings.Join(args[:len(args)-1], " ")
+	password := args[len(args)-1]
+	scope := "group:" + groupName
+
+	if wait := b.Limiter.Allow(client, scope); wait > 0 {
+		return tooManyAttempts(wait)
+	}
+	g, err := b.Groups.GetGroupByName(ctx, groupName)
+	if err != nil {
+		return "Invalid group or password."
+	}
+	if !services.CheckPassword(password, g.PasswordHash) {
+		b.Limiter.Fail(ctx, client, scope)
+		return "Invalid group or password."
+	}
+	if err := b.Links.LinkChat(ctx, transport, chatID, g.Name); err != nil {
+		return "Error: " + err.Error()
+	}
+	return "This chat is now linked to " + g.Name + "."
+}
+
+// tooManyAttempts is the reply to a throttled or locked out chat.
+func tooManyAttempts(wait time.Duration) string {
+	return fmt.Sprintf("Too many attempts, retry in %s.", wait.Round(time.Second))
+}
+
+// matches lists the pending matches, numbered for /score, and the latest results.
+func (b *Bot) matches(ctx context.Context, groupName string) string {
+	pending, err := b.Matches.PendingMatches(ctx, groupName, maxPendingMatches)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	completed, _, err := b.Matches.ListMatches(ctx, groupName, services.MatchFilter{Status: "completed"}, "", maxListedMatches)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if len(pending) == 0 && len(completed) == 0 {
+		return "No matches yet."
+	}
+
+	var sb strings.Builder
+	if len(pending) > 0 {
+		sb.WriteString("Pending matches:\n")
+		for i, m := range pending {
+			fmt.Fprintf(&sb, "#%d %s\n", i+1, teams(m))
+		}
+	}
+	if len(completed) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("Latest results:\n")
+		for _, m := range completed {
+			fmt.Fprintf(&sb, "%s %s %s\n", m.Timestamp.Format("02/01"), teams(m), score(m))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// stats shows the standings sorted by win rate.
+func (b *Bot) stats(ctx context.Context, groupName string) string {
+	stats, err := b.Stats.ComputeStats(ctx, groupName)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if len(stats) == 0 {
+		return "No completed matches yet."
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].GameWinRate != stats[j].GameWinRate {
+			return stats[i].GameWinRate > stats[j].GameWinRate
+		}
+		return stats[i].TotalGames > stats[j].TotalGames
+	})
+
+	var sb strings.Builder
+	sb.WriteString("Standings of " + groupName + ":\n")
+	for i, s := range stats {
+		fmt.Fprintf(&sb, "%d. %s - %d/%d won (%.0f%%)\n", i+1, s.PlayerName, s.GamesWon, s.TotalGames, s.GameWinRate)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// newMatch handles /newmatch A B vs C D.
+func (b *Bot) newMatch(ctx context.Context, groupName string, args []string) string {
+	left, right, ok := strings.Cut(strings.Join(args, " "), " vs ")
+	if !ok {
+		return "Usage: /newmatch A B vs C D"
+	}
+
+	players, err := b.Players.ListPlayers(ctx, groupName, false)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	byName := make(map[string]primitive.ObjectID, len(players))
+	for _, p := range players {
+		byName[strings.ToLower(p.Name)] = p.ID
+	}
+
+	team1, err := resolveTeam(strings.Fields(left), byName)
+	if err != nil {
+		return err.Error()
+	}
+	team2, err := resolveTeam(strings.Fields(right), byName)
+	if err != nil {
+		return err.Error()
+	}
+
+	match, err := b.Matches.CreateMatch(ctx, groupName, append(team1, team2...))
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return "Match created: " + teams(match)
+}
+
+// score handles /score [#n] 6-4 [3-6 6-3]. The sets must decide the match,
+// played as a single set, or as the best of 3 or 5 sets when more are given.
+func (b *Bot) score(ctx context.Context, groupName string, args []string) string {
+	index := 1
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		n, err := strconv.Atoi(args[0][1:])
+		if err != nil || n < 1 || n > maxPendingMatches {
+			return "Invalid match number."
+		}
+		index = n
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "Usage: /score 6-4 [3-6 6-3]"
+	}
+	if len(args) > 5 {
+		return "At most 5 sets can be played."
+	}
+
+	sets := make([][2]int, 0, len(args))
+	for _, set := range args {
+		a, c, ok := strings.Cut(set, "-")
+		s1, err1 := strconv.Atoi(a)
+		s2, err2 := strconv.Atoi(c)
+		if !ok || err1 != nil || err2 != nil {
+			return "Invalid set score " + set + ", expected for example 6-4."
+		}
+		sets = append(sets, [2]int{s1, s2})
+	}
+	format := scoredFormat(len(sets))
+	if _, err := format.Decide(sets); err != nil {
+		return "Invalid result: " + err.Error() + "."
+	}
+
+	pending, err := b.Matches.PendingMatches(ctx, groupName, index)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if index > len(pending) {
+		return "No such pending match, see /matches."
+	}
+	match := pending[index-1]
+
+	if err := b.Matches.SubmitScoredResults(ctx, groupName, match.ID, format, sets); err != nil {
+		return "Error: " + err.Error()
+	}
+	match.Sets = sets
+	return "Result saved: " + teams(match) + " " + score(match)
+}
+
+// scoredFormat returns the format of a match of the given number of sets:
+// a single set, or the best of 3 or 5 sets.
+func scoredFormat(sets int) scoring.Format {
+	format := scoring.DefaultFormat
+	switch {
+	case sets > 3:
+		format.Sets = 5
+	case sets > 1:
+		format.Sets = 3
+	}
+	return format
+}
+
+// resolveTeam finds the two players named in words. Names may contain
+// spaces, so every split of the words is tried.
+func resolveTeam(words []string, byName map[string]primitive.ObjectID) ([]primitive.ObjectID, error) {
+	for i := 1; i < len(words); i++ {
+		a, okA := byName[strings.ToLower(strings.Join(words[:i], " "))]
+		c, okC := byName[strings.ToLower(strings.Join(words[i:], " "))]
+		if okA && okC {
+			return []primitive.ObjectID{a, c}, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find two players named %q in the group.", strings.Join(words, " "))
+}
+
+// score formats the result of a match, set by set when known.
+func score(m models.MatchResponse) string {
+	if len(m.Sets) == 0 {
+		return fmt.Sprintf("%d-%d", m.ScoreTeam1, m.ScoreTeam2)
+	}
+	sets := make([]string, 0, len(m.Sets))
+	for _, set := range m.Sets {
+		sets = append(sets, fmt.Sprintf("%d-%d", set[0], set[1]))
+	}
+	return strings.Join(sets, " ")
+}
+
+// teams formats the teams of a match.
+func teams(m models.MatchResponse) string {
+	names := func(ps []models.PlayerInfo) string {
+		n := make([]string, 0, len(ps))
+		for _, p := range ps {
+			n = append(n, p.Name)
+		}
+		return strings.Join(n, " & ")
+	}
+	return names(m.Team1) + " vs " + names(m.Team2)
+}
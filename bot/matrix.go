@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// matrixSyncTimeout is the long polling timeout of /sync, in milliseconds.
+const matrixSyncTimeout = 30000
+
+// Matrix is a transport for the Matrix client-server API. The bot joins the
+// rooms it is invited to; rooms are used as chats.
+type Matrix struct {
+	homeserver  string
+	accessToken string
+	client      *http.Client
+	userID      string
+	txn         int64
+}
+
+// NewMatrix creates a Matrix transport for the account of accessToken.
+func NewMatrix(homeserver, accessToken string) *Matrix {
+	return &Matrix{
+		homeserver:  strings.TrimRight(homeserver, "/"),
+		accessToken: accessToken,
+		client:      &http.Client{Timeout: matrixSyncTimeout*time.Millisecond + 10*time.Second},
+	}
+}
+
+// Name implements Transport.
+func (m *Matrix) Name() string {
+	return "matrix"
+}
+
+// Receive implements Transport.
+func (m *Matrix) Receive(ctx context.Context, handle func(Message)) error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := m.do(ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &whoami); err != nil {
+		return err
+	}
+	m.userID = whoami.UserID
+
+	since := ""
+	for {
+		var sync struct {
+			NextBatch string `json:"next_batch"`
+			Rooms     struct {
+				Join map[string]struct {
+					Timeline struct {
+						Events []struct {
+							Type    string `json:"type"`
+							Sender  string `json:"sender"`
+							Content struct {
+								MsgType string `json:"msgtype"`
+								Body    string `json:"body"`
+							} `json:"content"`
+						} `json:"events"`
+					} `json:"timeline"`
+				} `json:"join"`
+				Invite map[string]json.RawMessage `json:"invite"`
+			} `json:"rooms"`
+		}
+
+		query := url.Values{}
+		query.Set("timeout", strconv.Itoa(matrixSyncTimeout))
+		if since != "" {
+			query.Set("since", since)
+		}
+		err := m.do(ctx, http.MethodGet, "/_matrix/client/v3/sync?"+query.Encode(), nil, &sync)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for roomID := range sync.Rooms.Invite {
+			if err := m.do(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(roomID), struct{}{}, nil); err != nil {
+				slog.WarnContext(ctx, "bot: cannot join matrix room", "room", roomID, "error", err)
+			}
+		}
+
+		// The first sync returns the room history, only later messages are answered
+		if since != "" {
+			for roomID, room := range sync.Rooms.Join {
+				for _, ev := range room.Timeline.Events {
+					if ev.Type != "m.room.message" || ev.Content.MsgType != "m.text" || ev.Sender == m.userID {
+						continue
+					}
+					handle(Message{ChatID: roomID, Sender: ev.Sender, Text: ev.Content.Body})
+				}
+			}
+		}
+		since = sync.NextBatch
+	}
+}
+
+// Send implements Transport.
+func (m *Matrix) Send(ctx context.Context, chatID, text string) error {
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatInt(atomic.AddInt64(&m.txn, 1), 10)
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(chatID) + "/send/m.room.message/" + txnID
+	return m.do(ctx, http.MethodPut, path, map[string]string{
+		"msgtype": "m.text",
+		"body":    text,
+	}, nil)
+}
+
+// do performs an authenticated request and decodes the response into result.
+func (m *Matrix) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, m.homeserver+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("matrix %s %s: %d %s", method, path, resp.StatusCode, apiErr.Error)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
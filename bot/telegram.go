@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTelegramAPI is the base URL of the Telegram Bot API.
+const DefaultTelegramAPI = "https://api.telegram.org"
+
+// telegramPollTimeout is the long polling timeout of getUpdates, in seconds.
+const telegramPollTimeout = 30
+
+// Telegram is a transport for the Telegram Bot API using long polling.
+type Telegram struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// NewTelegram creates a Telegram transport. An empty baseURL uses the
+// public Telegram Bot API.
+func NewTelegram(token, baseURL string) *Telegram {
+	if baseURL == "" {
+		baseURL = DefaultTelegramAPI
+	}
+	return &Telegram{
+		token:   token,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: (telegramPollTimeout + 10) * time.Second},
+	}
+}
+
+// Name implements Transport.
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+// Receive implements Transport.
+func (t *Telegram) Receive(ctx context.Context, handle func(Message)) error {
+	offset := 0
+	for {
+		var updates []struct {
+			UpdateID int `json:"update_id"`
+			Message  *struct {
+				Text string `json:"text"`
+				Chat struct {
+					ID int64 `json:"id"`
+				} `json:"chat"`
+				From struct {
+					Username  string `json:"username"`
+					FirstName string `json:"first_name"`
+				} `json:"from"`
+			} `json:"message"`
+		}
+		err := t.call(ctx, "getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         telegramPollTimeout,
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// Back off before polling again on network or API errors
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.Text == "" {
+				continue
+			}
+			sender := u.Message.From.Username
+			if sender == "" {
+				sender = u.Message.From.FirstName
+			}
+			handle(Message{
+				ChatID: strconv.FormatInt(u.Message.Chat.ID, 10),
+				Sender: sender,
+				Text:   u.Message.Text,
+			})
+		}
+	}
+}
+
+// Send implements Transport.
+func (t *Telegram) Send(ctx context.Context, chatID, text string) error {
+	return t.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}, nil)
+}
+
+// call invokes a Bot API method and decodes its result into result.
+func (t *Telegram) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/bot"+t.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	if !envelope.OK {
+		return fmt.Errorf("telegram %s: %s", method, envelope.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
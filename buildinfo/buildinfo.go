@@ -0,0 +1,60 @@
+// Package buildinfo describes the running binary. Release builds set its
+// variables with the linker, as the Makefile does:
+//
+//	go build -ldflags "-X github.com/p4u/padelfriends/buildinfo.Version=v1.2.0"
+//
+// Otherwise the commit and its time come from the version control data
+// stamped by the go command.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the release of the binary, "dev" for development builds.
+	Version = "dev"
+	// Commit is the revision the binary was built from.
+	Commit = ""
+	// Date is the time of the commit, or of the build when set by the linker.
+	Date = ""
+)
+
+// Info is the description of the binary in the health reports.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the description of the binary.
+func Get() Info {
+	return Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+}
+
+func init() {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	stamped, modified := false, false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if Commit == "" {
+				Commit, stamped = s.Value, true
+			}
+		case "vcs.time":
+			if Date == "" {
+				Date = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if stamped && modified {
+		Commit += "-dirty"
+	}
+}
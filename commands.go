@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/p4u/padelfriends/config"
+	"github.com/p4u/padelfriends/db"
+	"github.com/p4u/padelfriends/services"
+)
+
+// command is a subcommand of the binary, working on the database through
+// the services.
+type command struct {
+	name    string // words selecting the command, such as "group create"
+	args    string // synopsis of its flags and arguments
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+// commands are listed in the order of the usage.
+var commands = []*command{
+	{name: "serve", summary: "Run the server (the default)", run: runServe},
+	{name: "config print", args: "[flags]", summary: "Print the configuration, with the secrets masked"},
+	{name: "group list", summary: "List the groups", run: runGroupList},
+	{name: "group create", args: "[-password PASSWORD] NAME", summary: "Create a group, with a generated password unless given", run: runGroupCreate},
+	{name: "group delete", args: "-yes NAME", summary: "Delete a group with its players, matches and avatars", run: runGroupDelete},
+	{name: "group reset-password", args: "[-password PASSWORD] NAME", summary: "Set the password of a group, generated unless given", run: runGroupResetPassword},
+	{name: "group rename", args: "NAME NEW_NAME", summary: "Rename a group, keeping its links", run: runGroupRename},
+	{name: "player merge", args: "GROUP DUPLICATE_ID CANONICAL_ID", summary: "Merge a duplicate player into another one", run: runPlayerMerge},
+	{name: "stats rebuild", args: "[GROUP]", summary: "Recompute the guest flags the statistics rely on, of every group by default", run: runStatsRebuild},
+	{name: "export", args: "[-o FILE] [-csv] GROUP", summary: "Write the archive of a group, or its matches as CSV", run: runExport},
+	{name: "import", args: "[-name NAME] FILE", summary: "Create a group from an archive, - reading the standard input", run: runImport},
+	{name: "migrate", args: "[-dry-run]", summary: "Apply the pending database migrations", run: runMigrate},
+	{name: "migrate status", summary: "List the database migrations and when they were applied", run: runMigrateStatus},
+}
+
+// errUsage reports invalid arguments, after the usage of the command was
+// printed.
+var errUsage = errors.New("invalid arguments")
+
+// printUsage writes the synopsis of every command.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: padelfriends [flags] [command]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nThe flags set the configuration; run padelfriends -h to list them.\n"+
+		"Commands accept -json to print JSON; run padelfriends COMMAND -h for their flags.\n")
+}
+
+// runCommand runs the command named by the first arguments and returns the
+// exit code.
+func runCommand(cfg *config.Config, args []string) int {
+	cmd, args := findCommand(args)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", strings.Join(args, " "))
+		printUsage(os.Stderr)
+		return 2
+	}
+	if cmd.run == nil {
+		fmt.Fprintf(os.Stderr, "%s takes the configuration flags after it: padelfriends %s %s\n", cmd.name, cmd.name, cmd.args)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := &cli{ctx: ctx, cfg: cfg, cmd: cmd, out: os.Stdout}
+	defer c.close()
+
+	err := cmd.run(c, args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+	return 1
+}
+
+// findCommand returns the command with the most words starting the
+// arguments, with the arguments following them.
+func findCommand(args []string) (*command, []string) {
+	var found *command
+	n := 0
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(words) <= n || len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			found, n = cmd, len(words)
+		}
+	}
+	return found, args[n:]
+}
+
+// cli holds the state of a running command.
+type cli struct {
+	ctx  context.Context
+	cfg  *config.Config
+	cmd  *command
+	out  io.Writer
+	json bool
+
+	mdb     *db.MongoDB
+	groups  *services.GroupService
+	players *services.PlayerService
+	stats   *services.StatsService
+}
+
+// flags returns the flag set of the command, printing its usage on -h.
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&c.json, "json", false, "print JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: padelfriends %s %s\n\n%s.\n\n", c.cmd.name, c.cmd.args, c.cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of the command and checks that between min and max
+// arguments follow them.
+func (c *cli) parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// connect connects to the database and creates the services.
+func (c *cli) connect() error {
+	mdb, err := db.Connect(c.cfg.MongoDB, nil)
+	if err != nil {
+		return fmt.Errorf("cannot connect to MongoDB: %w", err)
+	}
+	c.mdb = mdb
+	c.groups = services.NewGroupService(mdb.Database)
+	c.players = services.NewPlayerService(mdb.Database, nil, nil)
+	c.stats = services.NewStatsService(mdb.Database)
+	return nil
+}
+
+func (c *cli) close() {
+	if c.mdb != nil {
+		c.mdb.Client.Disconnect(context.Background())
+	}
+}
+
+// print writes v as JSON with -json, or calls text to write it for humans
+// as tab-separated columns.
+func (c *cli) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// generatePassword returns a random password for the groups.
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func runServe(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+	serve(c.cfg)
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/p4u/padelfriends/migrations"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func runPlayerMerge(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 3, 3)
+	if err != nil {
+		return err
+	}
+	duplicate, err := primitive.ObjectIDFromHex(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid player ID %q", args[1])
+	}
+	canonical, err := primitive.ObjectIDFromHex(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid player ID %q", args[2])
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	rewritten, err := c.players.MergePlayers(c.ctx, args[0], duplicate, canonical)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Merged  int64 `json:"merged"`
+		Matches int64 `json:"matches"`
+	}{1, rewritten}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Merged player %s into %s, rewriting %d matches\n", args[1], args[2], rewritten)
+	})
+}
+
+func runStatsRebuild(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	group := ""
+	if len(args) > 0 {
+		group = args[0]
+		if _, err := c.groups.GetGroupByName(c.ctx, group); err != nil {
+			return err
+		}
+	}
+	changed, err := c.stats.RebuildGuestFlags(c.ctx, group)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Updated int64 `json:"updated"`
+	}{changed}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Updated %d matches\n", changed)
+	})
+}
+
+func runExport(c *cli, args []string) error {
+	fs := c.flags()
+	output := fs.String("o", "-", "output `file`, - for the standard output")
+	csv := fs.Bool("csv", false, "write the matches as CSV instead of the archive")
+	args, err := c.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	var data []byte
+	if *csv {
+		if _, err := c.groups.GetGroupByName(c.ctx, args[0]); err != nil {
+			return err
+		}
+		text, err := c.groups.ExportGroupMatchesCSV(c.ctx, args[0])
+		if err != nil {
+			return err
+		}
+		data = []byte(text)
+	} else {
+		archive, err := c.groups.ExportGroup(c.ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if data, err = json.MarshalIndent(archive, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+
+	if *output == "-" {
+		_, err = c.out.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0o600)
+}
+
+func runImport(c *cli, args []string) error {
+	fs := c.flags()
+	name := fs.String("name", "", "name of the group, the archived one when empty")
+	args, err := c.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		if in, err = os.Open(args[0]); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	var archive services.GroupArchive
+	if err := json.NewDecoder(in).Decode(&archive); err != nil {
+		return fmt.Errorf("invalid archive: %v", err)
+	}
+
+	if err := c.connect(); err != nil {
+		return err
+	}
+	group, err := c.groups.ImportGroup(c.ctx, archive, *name)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Name    string `json:"name"`
+		Slug    string `json:"slug"`
+		Players int    `json:"players"`
+		Matches int    `json:"matches"`
+	}{group.Name, group.Slug, len(archive.Players), len(archive.Matches)}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Imported group %s, slug %s, with %d players and %d matches\n",
+			result.Name, result.Slug, result.Players, result.Matches)
+	})
+}
+
+func runMigrate(c *cli, args []string) error {
+	fs := c.flags()
+	dryRun := fs.Bool("dry-run", false, "report what the migrations would change, without applying them")
+	if _, err := c.parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	results, err := migrations.New(c.mdb.Database).Up(c.ctx, *dryRun)
+	if perr := c.print(results, func(w io.Writer) {
+		if len(results) == 0 && err == nil {
+			fmt.Fprintln(w, "The database is up to date")
+		}
+		for _, res := range results {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", res.Version, res.Description, res.Report)
+		}
+	}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func runMigrateStatus(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	status, err := migrations.New(c.mdb.Database).Status(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(status, func(w io.Writer) {
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Description, applied)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/p4u/padelfriends/services"
+)
+
+func runGroupList(c *cli, args []string) error {
+	if _, err := c.parse(c.flags(), args, 0, 0); err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	groups, err := c.groups.ListGroups(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(groups, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSLUG\tCREATED")
+		for _, g := range groups {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", g.Name, g.Slug, g.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+	})
+}
+
+// groupPassword is the output of the commands setting a group password.
+type groupPassword struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug,omitempty"`
+	Password string `json:"password,omitempty"` // only when generated
+}
+
+func runGroupCreate(c *cli, args []string) error {
+	fs := c.flags()
+	password := fs.String("password", "", "password of the group, generated when empty")
+	args, err := c.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	result := groupPassword{Name: args[0]}
+	if *password == "" {
+		if result.Password, err = generatePassword(); err != nil {
+			return err
+		}
+		*password = result.Password
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	group, err := c.groups.CreateGroup(c.ctx, args[0], *password)
+	if err != nil {
+		return err
+	}
+	result.Slug = group.Slug
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Created group %s, slug %s\n", group.Name, group.Slug)
+		if result.Password != "" {
+			fmt.Fprintf(w, "Password: %s\n", result.Password)
+		}
+	})
+}
+
+func runGroupDelete(c *cli, args []string) error {
+	fs := c.flags()
+	yes := fs.Bool("yes", false, "confirm the deletion, which cannot be undone")
+	args, err := c.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return errors.New("deleting a group cannot be undone, confirm with -yes")
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	deleted, err := c.groups.DeleteGroup(c.ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	// The files go last, once the players referencing them are gone
+	if store, err := services.NewAvatarStore(c.cfg.AvatarDir); err != nil {
+		slog.Warn("Cannot open the avatar store", "error", err)
+	} else {
+		for _, name := range deleted.Avatars {
+			if err := store.Remove(name); err != nil {
+				slog.Warn("Cannot remove avatar", "avatar", name, "error", err)
+			}
+		}
+	}
+
+	return c.print(deleted, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted group %s with %d players and %d matches\n", args[0], deleted.Players, deleted.Matches)
+	})
+}
+
+func runGroupResetPassword(c *cli, args []string) error {
+	fs := c.flags()
+	password := fs.String("password", "", "new password of the group, generated when empty")
+	args, err := c.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	result := groupPassword{Name: args[0]}
+	if *password == "" {
+		if result.Password, err = generatePassword(); err != nil {
+			return err
+		}
+		*password = result.Password
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	if err := c.groups.SetPassword(c.ctx, args[0], *password); err != nil {
+		return err
+	}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Changed the password of group %s\n", args[0])
+		if result.Password != "" {
+			fmt.Fprintf(w, "Password: %s\n", result.Password)
+		}
+	})
+}
+
+func runGroupRename(c *cli, args []string) error {
+	args, err := c.parse(c.flags(), args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	if err := c.groups.RenameGroup(c.ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	group, err := c.groups.GetGroupByName(c.ctx, args[1])
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Name    string `json:"name"`
+		NewName string `json:"new_name"`
+		Slug    string `json:"slug"`
+	}{args[0], group.Name, group.Slug}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Renamed group %s to %s, slug %s\n", result.Name, result.NewName, result.Slug)
+	})
+}
@@ -1,37 +1,451 @@
+// Package config loads the configuration of the server from, in increasing
+// order of precedence, the defaults, a YAML file, the environment and the
+// command-line flags.
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds configuration values for the application.
 type Config struct {
-	MongoURI string
-	Port     int
+	MongoDB   MongoDBConfig
+	Server    ServerConfig
+	AvatarDir string
+	SMTP      SMTPConfig
+	Bot       BotConfig
+	Static    StaticConfig
+	Metrics   MetricsConfig
+	Log       LogConfig
+	RateLimit RateLimitConfig
+	Webhooks  WebhooksConfig
+	Features  FeaturesConfig
 }
 
-// Load reads environment variables and returns a Config struct.
-// Defaults to port 7777 if not specified.
-func Load() (*Config, error) {
-	port := 7777
-	if p := os.Getenv("PORT"); p != "" {
-		pp, err := strconv.Atoi(p)
+// MongoDBConfig holds the database connection settings.
+type MongoDBConfig struct {
+	URI            string
+	Database       string
+	ConnectTimeout time.Duration
+
+	// Migrate applies the pending migrations at startup. Otherwise they are
+	// applied with the migrate command.
+	Migrate bool
+}
+
+// ServerConfig holds the settings of the HTTP server.
+type ServerConfig struct {
+	// Listen is the address of the API, such as ":7777".
+	Listen string
+
+	// TLSCert and TLSKey serve HTTPS when both are set.
+	TLSCert string
+	TLSKey  string
+
+	// CORSOrigins are the origins allowed to call the API from a browser,
+	// or "*" for any. Cross-origin requests are refused when empty.
+	CORSOrigins []string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// WriteTimeout is disabled by default, as it would cut the event
+	// streams and the live scoring connections.
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// StopTimeout is the time given to each background worker, such as the
+	// webhook deliveries, and to the database client to stop.
+	StopTimeout time.Duration
+
+	// DrainDelay is how long /readyz fails before the shutdown, for the load
+	// balancers to stop sending requests.
+	DrainDelay time.Duration
+
+	// HealthToken authenticates the detailed health report, which is
+	// disabled when empty.
+	HealthToken string
+}
+
+// TLS reports whether the server is configured to serve HTTPS.
+func (c ServerConfig) TLS() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// FeaturesConfig toggles the optional parts of the server.
+type FeaturesConfig struct {
+	// Notifications sends the emails when an SMTP server is configured.
+	Notifications bool
+
+	// Bot runs the chat bot transports that are configured.
+	Bot bool
+
+	// Webhooks delivers the events to the webhooks of the groups.
+	Webhooks bool
+
+	// OpenAPIValidate checks the API responses against the OpenAPI document
+	// and logs the contract violations.
+	OpenAPIValidate bool
+}
+
+// WebhooksConfig holds the settings of the webhook deliveries.
+type WebhooksConfig struct {
+	// AllowPrivate lets the webhooks target loopback, private and link-local
+	// addresses, which are refused by default so that the groups cannot reach
+	// the services of the internal network. Only meant for local testing.
+	AllowPrivate bool
+}
+
+// BotConfig holds the chat bot credentials. Each transport is enabled when
+// its credentials are set.
+type BotConfig struct {
+	TelegramToken    string
+	TelegramAPIURL   string // optional, defaults to the public Bot API
+	MatrixHomeserver string
+	MatrixToken      string
+}
+
+// Enabled reports whether any chat bot transport is configured.
+func (c BotConfig) Enabled() bool {
+	return c.TelegramToken != "" || c.MatrixHomeserver != ""
+}
+
+// StaticConfig holds the settings of the web application serving.
+type StaticConfig struct {
+	// Dir serves the web application from a directory instead of the copy
+	// embedded with the embedui build tag. Its files are indexed at startup,
+	// unless Live is set.
+	Dir string
+
+	// Live looks the files of the directory up on every request, so that a
+	// rebuilt application is served without a restart. For development only.
+	Live bool
+
+	// ContentSecurityPolicy overrides the default policy of the web
+	// application, e.g. to allow an API served from another origin.
+	ContentSecurityPolicy string
+
+	// HSTSMaxAge enables Strict-Transport-Security with this max-age when
+	// positive. Only enable it when the server is reached over HTTPS.
+	HSTSMaxAge time.Duration
+}
+
+// MetricsConfig holds the settings of the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	// Enabled serves the metrics on /metrics.
+	Enabled bool
+
+	// Addr is the listener of the metrics, such as "127.0.0.1:9090". The
+	// metrics are never served on the public port of the API.
+	Addr string
+}
+
+// LogConfig holds the settings of the application and access logs.
+type LogConfig struct {
+	Level  slog.Level // minimum level of the application log
+	Format string     // "text" or "json"
+
+	// AccessLog is where the requests are logged: "stdout", "stderr",
+	// a file path or "off". The application log goes to stderr.
+	AccessLog string
+
+	// AuditLog is where the security events, such as the lockouts, are
+	// logged, in the same way as AccessLog.
+	AuditLog string
+}
+
+// RateLimitConfig holds the limits of the password guesses.
+type RateLimitConfig struct {
+	// Enabled throttles the requests carrying a password and locks out the
+	// clients failing too many of them.
+	Enabled bool
+
+	// Backend keeps the counters; only "memory" is available, which limits
+	// the clients per instance of the server.
+	Backend string
+
+	// TrustProxy takes the client address from X-Forwarded-For, which must
+	// then be set by a reverse proxy.
+	TrustProxy bool
+
+	// ClientPerMinute are the authenticated requests allowed a minute per
+	// client and GroupPerMinute the wrong passwords per group, from any
+	// client, after bursts of ClientBurst and GroupBurst. Zero disables the
+	// limit.
+	ClientPerMinute int
+	ClientBurst     int
+	GroupPerMinute  int
+	GroupBurst      int
+
+	// MaxFailures wrong passwords within FailureWindow lock a client out for
+	// Lockout, doubled at each further one up to MaxLockout. Zero disables
+	// the lockouts.
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+}
+
+// SMTPConfig holds the email notification settings. Notifications are
+// disabled when Host is empty.
+type SMTPConfig struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	TemplateDir string       // optional directory overriding the built-in templates
+	DigestDay   time.Weekday // day of the weekly group digest
+	DigestHour  int          // local hour of the weekly group digest
+}
+
+// Enabled reports whether email notifications are configured.
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// Default returns the configuration used for the settings that are not set.
+func Default() *Config {
+	return &Config{
+		MongoDB: MongoDBConfig{
+			Database:       "padelfriends",
+			ConnectTimeout: 10 * time.Second,
+			Migrate:        true,
+		},
+		Server: ServerConfig{
+			Listen:            ":7777",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   5 * time.Second,
+			StopTimeout:       5 * time.Second,
+			DrainDelay:        5 * time.Second,
+		},
+		AvatarDir: "data/avatars",
+		SMTP: SMTPConfig{
+			Port:       25,
+			DigestDay:  time.Monday,
+			DigestHour: 9,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Addr:    "127.0.0.1:9090",
+		},
+		Log: LogConfig{
+			Level:     slog.LevelInfo,
+			Format:    "text",
+			AccessLog: "stdout",
+			AuditLog:  "stderr",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:         true,
+			Backend:         "memory",
+			ClientPerMinute: 60,
+			ClientBurst:     20,
+			GroupPerMinute:  300,
+			GroupBurst:      60,
+			MaxFailures:     5,
+			FailureWindow:   time.Hour,
+			Lockout:         time.Minute,
+			MaxLockout:      30 * time.Minute,
+		},
+		Features: FeaturesConfig{
+			Notifications: true,
+			Bot:           true,
+			Webhooks:      true,
+		},
+	}
+}
+
+// Load returns the configuration layered from the defaults, the YAML file
+// named by the -config flag or CONFIG_FILE, the environment and the flags
+// in args, and the arguments following the flags. When only the validation
+// fails, the configuration is returned along with an error listing every
+// problem.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	table := settings(cfg)
+
+	flags := flag.NewFlagSet("padelfriends", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration `file`")
+	set := map[string]string{}
+	for _, s := range table {
+		flags.Var(&flagValue{s: s, set: set}, s.key, s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var problems []error
+	if *file != "" {
+		values, err := readFile(*file)
 		if err != nil {
-			return nil, fmt.Errorf("invalid PORT: %v", err)
+			return nil, nil, err
+		}
+		problems = append(problems, apply(table, values, func(s *setting) string {
+			return *file + ": " + s.key
+		})...)
+		for key := range values {
+			if lookup(table, key) == nil {
+				problems = append(problems, fmt.Errorf("%s: unknown setting %s", *file, key))
+			}
+		}
+	}
+	problems = append(problems, applyEnv(table)...)
+	problems = append(problems, apply(table, set, func(s *setting) string {
+		return "-" + s.key
+	})...)
+
+	problems = append(problems, cfg.validate()...)
+	return cfg, flags.Args(), errors.Join(problems...)
+}
+
+// apply sets the settings found in values, naming the source of the
+// invalid ones with source.
+func apply(table []*setting, values map[string]string, source func(*setting) string) []error {
+	var problems []error
+	for _, s := range table {
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.value.Set(v); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", source(s), err))
+		}
+	}
+	return problems
+}
+
+// applyEnv sets the settings found in the environment.
+func applyEnv(table []*setting) []error {
+	values := map[string]string{}
+	for _, s := range table {
+		if v := os.Getenv(s.env); v != "" {
+			values[s.key] = v
+		}
+	}
+	// PORT predates LISTEN_ADDR
+	if port := os.Getenv("PORT"); port != "" && os.Getenv("LISTEN_ADDR") == "" {
+		values["server.listen"] = ":" + port
+	}
+	return apply(table, values, func(s *setting) string { return s.env })
+}
+
+// validate returns every problem of the configuration.
+func (c *Config) validate() []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.MongoDB.URI == "" {
+		problem("mongodb.uri must be set (MONGODB_URI)")
+	}
+	if c.MongoDB.Database == "" || strings.ContainsAny(c.MongoDB.Database, `/\. "$`) {
+		problem("mongodb.database: invalid database name %q", c.MongoDB.Database)
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		problem("server.listen: %v", err)
+	} else if _, err := strconv.Atoi(port); err != nil {
+		problem("server.listen: invalid port %q", port)
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		problem("server.tls_cert and server.tls_key must be set together")
+	}
+	for _, file := range []string{c.Server.TLSCert, c.Server.TLSKey} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			problem("server: %v", err)
+		}
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			problem("server.cors_origins: invalid origin %q", origin)
+		}
+	}
+	if c.MongoDB.ConnectTimeout <= 0 {
+		problem("mongodb.connect_timeout: must be positive")
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"server.stop_timeout", c.Server.StopTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+		{"static.hsts_max_age", c.Static.HSTSMaxAge},
+		{"rate_limit.failure_window", c.RateLimit.FailureWindow},
+		{"rate_limit.lockout", c.RateLimit.Lockout},
+		{"rate_limit.max_lockout", c.RateLimit.MaxLockout},
+	} {
+		if d.value < 0 {
+			problem("%s: must not be negative", d.key)
 		}
-		port = pp
 	}
 
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		return nil, fmt.Errorf("MONGODB_URI environment variable not set")
+	if c.Metrics.Enabled {
+		if c.Metrics.Addr == "" {
+			problem("metrics.addr: required when the metrics are enabled")
+		} else if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			problem("metrics.addr: %v", err)
+		}
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		problem("log.format: must be text or json")
 	}
 
-	cfg := &Config{
-		MongoURI: mongoURI,
-		Port:     port,
+	if c.RateLimit.Backend != "memory" {
+		problem("rate_limit.backend: unknown backend %q", c.RateLimit.Backend)
+	}
+	for _, n := range []struct {
+		key   string
+		value int
+	}{
+		{"rate_limit.client_per_minute", c.RateLimit.ClientPerMinute},
+		{"rate_limit.client_burst", c.RateLimit.ClientBurst},
+		{"rate_limit.group_per_minute", c.RateLimit.GroupPerMinute},
+		{"rate_limit.group_burst", c.RateLimit.GroupBurst},
+		{"rate_limit.max_failures", c.RateLimit.MaxFailures},
+	} {
+		if n.value < 0 {
+			problem("%s: must not be negative", n.key)
+		}
+	}
+	if c.RateLimit.MaxLockout < c.RateLimit.Lockout {
+		problem("rate_limit.max_lockout: must not be shorter than rate_limit.lockout")
+	}
+
+	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+		problem("smtp.port: must be between 1 and 65535")
+	}
+	if c.SMTP.DigestHour < 0 || c.SMTP.DigestHour > 23 {
+		problem("smtp.digest_hour: must be between 0 and 23")
+	}
+	if c.SMTP.Enabled() && c.SMTP.From == "" {
+		problem("smtp.from must be set when smtp.host is set")
+	}
+	if c.Bot.MatrixHomeserver != "" && c.Bot.MatrixToken == "" {
+		problem("bot.matrix_token must be set when bot.matrix_homeserver is set")
 	}
-	return cfg, nil
+	return problems
 }
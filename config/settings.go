@@ -0,0 +1,309 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds a configuration field to its key in the file, which is also
+// the name of its flag, and to its environment variable.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  value
+}
+
+// value parses a setting from a string and returns its current value.
+type value interface {
+	Set(string) error
+	Get() interface{}
+}
+
+// settings returns the table of the settings bound to the fields of cfg.
+func settings(cfg *Config) []*setting {
+	return []*setting{
+		{key: "mongodb.uri", env: "MONGODB_URI", usage: "MongoDB connection URI", secret: true, value: (*stringValue)(&cfg.MongoDB.URI)},
+		{key: "mongodb.database", env: "MONGODB_DATABASE", usage: "MongoDB database name", value: (*stringValue)(&cfg.MongoDB.Database)},
+		{key: "mongodb.connect_timeout", env: "MONGODB_CONNECT_TIMEOUT", usage: "timeout of the initial MongoDB connection", value: (*durationValue)(&cfg.MongoDB.ConnectTimeout)},
+		{key: "mongodb.migrate", env: "MONGODB_MIGRATE", usage: "apply the pending database migrations at startup", value: (*boolValue)(&cfg.MongoDB.Migrate)},
+
+		{key: "server.listen", env: "LISTEN_ADDR", usage: "listen address of the API (PORT sets the port only)", value: (*stringValue)(&cfg.Server.Listen)},
+		{key: "server.tls_cert", env: "TLS_CERT", usage: "TLS certificate file, serving HTTPS with server.tls_key", value: (*stringValue)(&cfg.Server.TLSCert)},
+		{key: "server.tls_key", env: "TLS_KEY", usage: "TLS private key file", value: (*stringValue)(&cfg.Server.TLSKey)},
+		{key: "server.cors_origins", env: "CORS_ORIGINS", usage: "comma-separated origins allowed to call the API, or *", value: (*listValue)(&cfg.Server.CORSOrigins)},
+		{key: "server.read_header_timeout", env: "READ_HEADER_TIMEOUT", usage: "timeout reading the request headers", value: (*durationValue)(&cfg.Server.ReadHeaderTimeout)},
+		{key: "server.read_timeout", env: "READ_TIMEOUT", usage: "timeout reading a request", value: (*durationValue)(&cfg.Server.ReadTimeout)},
+		{key: "server.write_timeout", env: "WRITE_TIMEOUT", usage: "timeout writing a response, 0 for the event streams", value: (*durationValue)(&cfg.Server.WriteTimeout)},
+		{key: "server.idle_timeout", env: "IDLE_TIMEOUT", usage: "timeout of the idle keep-alive connections", value: (*durationValue)(&cfg.Server.IdleTimeout)},
+		{key: "server.shutdown_timeout", env: "SHUTDOWN_TIMEOUT", usage: "time given to the requests to complete on shutdown", value: (*durationValue)(&cfg.Server.ShutdownTimeout)},
+		{key: "server.stop_timeout", env: "STOP_TIMEOUT", usage: "time given to each background worker and the database client to stop", value: (*durationValue)(&cfg.Server.StopTimeout)},
+		{key: "server.drain_delay", env: "DRAIN_DELAY", usage: "time /readyz fails before the shutdown, 0 to stop at once", value: (*durationValue)(&cfg.Server.DrainDelay)},
+		{key: "server.health_token", env: "HEALTH_TOKEN", usage: "bearer token of /api/health/details, disabled when empty", secret: true, value: (*stringValue)(&cfg.Server.HealthToken)},
+
+		{key: "avatar_dir", env: "AVATAR_DIR", usage: "directory of the player avatars", value: (*stringValue)(&cfg.AvatarDir)},
+
+		{key: "static.dir", env: "UI_DIR", usage: "serve the web application from this directory", value: (*stringValue)(&cfg.Static.Dir)},
+		{key: "static.live", env: "UI_LIVE", usage: "look the web application files up on every request, for development", value: (*boolValue)(&cfg.Static.Live)},
+		{key: "static.content_security_policy", env: "CONTENT_SECURITY_POLICY", usage: "Content-Security-Policy of the web application", value: (*stringValue)(&cfg.Static.ContentSecurityPolicy)},
+		{key: "static.hsts_max_age", env: "HSTS_MAX_AGE", usage: "Strict-Transport-Security max-age, 0 to disable", value: (*durationValue)(&cfg.Static.HSTSMaxAge)},
+
+		{key: "metrics.enabled", env: "METRICS_ENABLED", usage: "serve the Prometheus metrics", value: (*boolValue)(&cfg.Metrics.Enabled)},
+		{key: "metrics.addr", env: "METRICS_ADDR", usage: "address of the metrics listener", value: (*stringValue)(&cfg.Metrics.Addr)},
+
+		{key: "log.level", env: "LOG_LEVEL", usage: "minimum level of the application log: debug, info, warn or error", value: (*levelValue)(&cfg.Log.Level)},
+		{key: "log.format", env: "LOG_FORMAT", usage: "log format: text or json", value: (*stringValue)(&cfg.Log.Format)},
+		{key: "log.access_log", env: "ACCESS_LOG", usage: "access log: stdout, stderr, a file or off", value: (*stringValue)(&cfg.Log.AccessLog)},
+		{key: "log.audit_log", env: "AUDIT_LOG", usage: "audit log of the security events: stdout, stderr, a file or off", value: (*stringValue)(&cfg.Log.AuditLog)},
+
+		{key: "rate_limit.enabled", env: "RATE_LIMIT_ENABLED", usage: "throttle the password guesses", value: (*boolValue)(&cfg.RateLimit.Enabled)},
+		{key: "rate_limit.backend", env: "RATE_LIMIT_BACKEND", usage: "store of the rate limits: memory", value: (*stringValue)(&cfg.RateLimit.Backend)},
+		{key: "rate_limit.trust_proxy", env: "RATE_LIMIT_TRUST_PROXY", usage: "take the client address from X-Forwarded-For", value: (*boolValue)(&cfg.RateLimit.TrustProxy)},
+		{key: "rate_limit.client_per_minute", env: "RATE_LIMIT_CLIENT_PER_MINUTE", usage: "authenticated requests a minute per client, 0 for no limit", value: (*intValue)(&cfg.RateLimit.ClientPerMinute)},
+		{key: "rate_limit.client_burst", env: "RATE_LIMIT_CLIENT_BURST", usage: "burst of authenticated requests per client", value: (*intValue)(&cfg.RateLimit.ClientBurst)},
+		{key: "rate_limit.group_per_minute", env: "RATE_LIMIT_GROUP_PER_MINUTE", usage: "wrong passwords a minute per group, 0 for no limit", value: (*intValue)(&cfg.RateLimit.GroupPerMinute)},
+		{key: "rate_limit.group_burst", env: "RATE_LIMIT_GROUP_BURST", usage: "burst of wrong passwords per group", value: (*intValue)(&cfg.RateLimit.GroupBurst)},
+		{key: "rate_limit.max_failures", env: "RATE_LIMIT_MAX_FAILURES", usage: "wrong passwords locking a client out, 0 for no lockout", value: (*intValue)(&cfg.RateLimit.MaxFailures)},
+		{key: "rate_limit.failure_window", env: "RATE_LIMIT_FAILURE_WINDOW", usage: "time after which the wrong passwords are forgotten", value: (*durationValue)(&cfg.RateLimit.FailureWindow)},
+		{key: "rate_limit.lockout", env: "RATE_LIMIT_LOCKOUT", usage: "first lockout, doubled at each further wrong password", value: (*durationValue)(&cfg.RateLimit.Lockout)},
+		{key: "rate_limit.max_lockout", env: "RATE_LIMIT_MAX_LOCKOUT", usage: "longest lockout", value: (*durationValue)(&cfg.RateLimit.MaxLockout)},
+
+		{key: "webhooks.allow_private", env: "WEBHOOKS_ALLOW_PRIVATE", usage: "let the webhooks target private and loopback addresses", value: (*boolValue)(&cfg.Webhooks.AllowPrivate)},
+
+		{key: "smtp.host", env: "SMTP_HOST", usage: "SMTP server of the email notifications", value: (*stringValue)(&cfg.SMTP.Host)},
+		{key: "smtp.port", env: "SMTP_PORT", usage: "SMTP server port", value: (*intValue)(&cfg.SMTP.Port)},
+		{key: "smtp.username", env: "SMTP_USERNAME", usage: "SMTP user name", value: (*stringValue)(&cfg.SMTP.Username)},
+		{key: "smtp.password", env: "SMTP_PASSWORD", usage: "SMTP password", secret: true, value: (*stringValue)(&cfg.SMTP.Password)},
+		{key: "smtp.from", env: "SMTP_FROM", usage: "sender address of the notifications", value: (*stringValue)(&cfg.SMTP.From)},
+		{key: "smtp.template_dir", env: "NOTIFY_TEMPLATE_DIR", usage: "directory overriding the notification templates", value: (*stringValue)(&cfg.SMTP.TemplateDir)},
+		{key: "smtp.digest_day", env: "DIGEST_DAY", usage: "day of the weekly group digest", value: (*weekdayValue)(&cfg.SMTP.DigestDay)},
+		{key: "smtp.digest_hour", env: "DIGEST_HOUR", usage: "local hour of the weekly group digest", value: (*intValue)(&cfg.SMTP.DigestHour)},
+
+		{key: "bot.telegram_token", env: "TELEGRAM_TOKEN", usage: "Telegram bot token", secret: true, value: (*stringValue)(&cfg.Bot.TelegramToken)},
+		{key: "bot.telegram_api_url", env: "TELEGRAM_API_URL", usage: "Telegram Bot API URL", value: (*stringValue)(&cfg.Bot.TelegramAPIURL)},
+		{key: "bot.matrix_homeserver", env: "MATRIX_HOMESERVER", usage: "Matrix homeserver URL", value: (*stringValue)(&cfg.Bot.MatrixHomeserver)},
+		{key: "bot.matrix_token", env: "MATRIX_ACCESS_TOKEN", usage: "Matrix access token", secret: true, value: (*stringValue)(&cfg.Bot.MatrixToken)},
+
+		{key: "features.notifications", env: "FEATURE_NOTIFICATIONS", usage: "send the email notifications", value: (*boolValue)(&cfg.Features.Notifications)},
+		{key: "features.bot", env: "FEATURE_BOT", usage: "run the chat bot", value: (*boolValue)(&cfg.Features.Bot)},
+		{key: "features.webhooks", env: "FEATURE_WEBHOOKS", usage: "deliver the webhooks", value: (*boolValue)(&cfg.Features.Webhooks)},
+		{key: "features.openapi_validate", env: "OPENAPI_VALIDATE", usage: "log the responses violating the OpenAPI document", value: (*boolValue)(&cfg.Features.OpenAPIValidate)},
+	}
+}
+
+// lookup returns the setting with the given key, or nil.
+func lookup(table []*setting, key string) *setting {
+	for _, s := range table {
+		if s.key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// readFile reads a YAML configuration file into the values of its settings,
+// keyed by their dotted path.
+func readFile(name string) (map[string]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	values := map[string]string{}
+	flatten("", doc, values)
+	return values, nil
+}
+
+func flatten(prefix string, doc map[string]interface{}, values map[string]string) {
+	for k, v := range doc {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(key+".", v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Print writes the configuration as YAML, with the secrets masked.
+func Print(w io.Writer, cfg *Config) error {
+	doc := map[string]interface{}{}
+	for _, s := range settings(cfg) {
+		v := s.value.Get()
+		if s.secret {
+			v = mask(v.(string))
+		}
+		section, name, nested := strings.Cut(s.key, ".")
+		if !nested {
+			doc[s.key] = v
+			continue
+		}
+		m, _ := doc[section].(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+			doc[section] = m
+		}
+		m[name] = v
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// mask hides a secret, keeping URIs readable without their password.
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+	return "********"
+}
+
+// flagValue records the flags, which are applied after the file and the
+// environment.
+type flagValue struct {
+	s   *setting
+	set map[string]string
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(v string) error {
+	f.set[f.s.key] = v
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	_, ok := f.s.value.(*boolValue)
+	return ok
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) Get() interface{} { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) Get() interface{} { return int(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) Get() interface{} { return bool(*v) }
+
+// durationValue parses Go durations such as "30s", or a number of seconds.
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		*v = durationValue(time.Duration(n) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) Get() interface{} { return time.Duration(*v).String() }
+
+// listValue parses comma-separated lists.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+func (v *listValue) Get() interface{} {
+	if *v == nil {
+		return []string{}
+	}
+	return []string(*v)
+}
+
+type levelValue slog.Level
+
+func (v *levelValue) Set(s string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return fmt.Errorf("invalid level %q", s)
+	}
+	*v = levelValue(level)
+	return nil
+}
+
+func (v *levelValue) Get() interface{} { return strings.ToLower(slog.Level(*v).String()) }
+
+// weekdayValue parses English day names.
+type weekdayValue time.Weekday
+
+func (v *weekdayValue) Set(s string) error {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), strings.TrimSpace(s)) {
+			*v = weekdayValue(d)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid day %q", s)
+}
+
+func (v *weekdayValue) Get() interface{} { return strings.ToLower(time.Weekday(*v).String()) }
@@ -2,9 +2,9 @@ package db
 
 import (
 	"context"
-	"time"
 
 	"github.com/p4u/padelfriends/config"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,13 +15,16 @@ type MongoDB struct {
 	Database *mongo.Database
 }
 
-// Connect initializes a MongoDB client and selects a database.
-// Database name can be hardcoded or configurable. We'll hardcode "padelfriends" for now.
-func Connect(cfg *config.Config) (*MongoDB, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Connect initializes a MongoDB client and selects the configured database.
+// The optional monitor observes the commands sent to the server.
+func Connect(cfg config.MongoDBConfig, monitor *event.CommandMonitor) (*MongoDB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
-	clientOpts := options.Client().ApplyURI(cfg.MongoURI)
+	clientOpts := options.Client().ApplyURI(cfg.URI)
+	if monitor != nil {
+		clientOpts.SetMonitor(monitor)
+	}
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return nil, err
@@ -32,7 +35,7 @@ func Connect(cfg *config.Config) (*MongoDB, error) {
 		return nil, err
 	}
 
-	db := client.Database("padelfriends")
+	db := client.Database(cfg.Database)
 
 	return &MongoDB{
 		Client:   client,
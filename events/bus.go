@@ -0,0 +1,178 @@
+// Package events provides an in-process publish/subscribe bus for group
+// events, with a short per-group history so that subscribers can resume
+// after a reconnection.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published by the services.
+const (
+	MatchCreated    = "match.created"
+	MatchCancelled  = "match.cancelled"
+	ResultSubmitted = "match.result_submitted"
+	ResultDisputed  = "match.result_disputed"
+	PlayerAdded     = "player.added"
+)
+
+// DefaultHistorySize is the number of events kept per group for resuming.
+const DefaultHistorySize = 100
+
+// subscriberBuffer is the number of pending events a subscriber may have
+// before it is considered too slow and dropped.
+const subscriberBuffer = 64
+
+// Event is a single notification about a change within a group.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	GroupName string      `json:"group_name"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Bus dispatches events to subscribers. A nil *Bus is valid and discards
+// every published event, so that services can run without one.
+type Bus struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	historySize int
+	history     map[string][]Event
+	subs        map[*Subscription]struct{}
+}
+
+// NewBus creates a Bus keeping historySize events per group.
+func NewBus(historySize int) *Bus {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Bus{
+		epoch:       strconv.FormatInt(time.Now().Unix(), 36),
+		historySize: historySize,
+		history:     make(map[string][]Event),
+		subs:        make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events of one group, or of all groups when
+// created with an empty group name.
+type Subscription struct {
+	bus       *Bus
+	groupName string
+	ch        chan Event
+	closeOnce sync.Once
+}
+
+// Events returns the channel delivering the events. It is closed when the
+// subscription is closed or when the subscriber falls too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close cancels the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *Subscription) closeLocked() {
+	s.closeOnce.Do(func() {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	})
+}
+
+// Publish records an event for a group and delivers it to the subscribers.
+func (b *Bus) Publish(groupName, eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := Event{
+		ID:        fmt.Sprintf("%s-%d", b.epoch, b.seq),
+		Type:      eventType,
+		GroupName: groupName,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+
+	h := append(b.history[groupName], ev)
+	if len(h) > b.historySize {
+		h = h[len(h)-b.historySize:]
+	}
+	b.history[groupName] = h
+
+	for sub := range b.subs {
+		if sub.groupName != "" && sub.groupName != groupName {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// Too slow: drop it, the client can resume from its last event
+			sub.closeLocked()
+		}
+	}
+}
+
+// Subscribe starts receiving the events of a group; an empty group name
+// subscribes to every group.
+func (b *Bus) Subscribe(groupName string) *Subscription {
+	sub, _ := b.SubscribeSince(groupName, "")
+	return sub
+}
+
+// SubscribeSince subscribes to a group and returns the recorded events that
+// followed lastID. Unknown or stale IDs, e.g. from before a restart, replay
+// the whole history kept for the group.
+func (b *Bus) SubscribeSince(groupName, lastID string) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		bus:       b,
+		groupName: groupName,
+		ch:        make(chan Event, subscriberBuffer),
+	}
+	b.subs[sub] = struct{}{}
+
+	if lastID == "" {
+		return sub, nil
+	}
+
+	history := b.history[groupName]
+	if seq, ok := b.parseID(lastID); ok {
+		for i, ev := range history {
+			if evSeq, _ := b.parseID(ev.ID); evSeq > seq {
+				return sub, append([]Event(nil), history[i:]...)
+			}
+		}
+		if seq <= b.seq {
+			return sub, nil
+		}
+	}
+	return sub, append([]Event(nil), history...)
+}
+
+// parseID extracts the sequence number of an event ID of the current epoch.
+func (b *Bus) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
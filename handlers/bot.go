@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/p4u/padelfriends/services"
+)
+
+// BotHandler issues the tokens linking chats to a group.
+type BotHandler struct {
+	GroupService *services.GroupService
+	BotService   *services.BotService
+}
+
+// POST /api/group/{name}/bot/link-token?password=SECRET
+// Returns a single-use token; sending "/link <token>" to the bot links the
+// chat to the group without sharing the group password in the chat.
+func (h *BotHandler) CreateLinkToken(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	token, expires, err := h.BotService.CreateLinkToken(r.Context(), groupName)
+	if err != nil {
+		writeServiceError(w, r, "Error creating link token", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, expires})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/p4u/padelfriends/services"
+)
+
+// Machine-readable codes of the error responses.
+const (
+	codeBadRequest   = "bad_request"
+	codeValidation   = "validation_failed"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeNotFound     = "not_found"
+	codeMethod       = "method_not_allowed"
+	codeConflict     = "conflict"
+	codeInternal     = "internal_error"
+)
+
+// errorResponse is the JSON envelope of every error response. Error keeps
+// the human readable message; details name the invalid input fields.
+type errorResponse struct {
+	Error   string        `json:"error"`
+	Code    string        `json:"code"`
+	Details []fieldDetail `json:"details,omitempty"`
+}
+
+type fieldDetail struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// serviceErrors maps the kinds of service errors to their HTTP status.
+var serviceErrors = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{services.ErrValidation, http.StatusBadRequest, codeValidation},
+	{services.ErrUnauthorized, http.StatusUnauthorized, codeUnauthorized},
+	{services.ErrForbidden, http.StatusForbidden, codeForbidden},
+	{services.ErrNotFound, http.StatusNotFound, codeNotFound},
+	{services.ErrConflict, http.StatusConflict, codeConflict},
+}
+
+// writeError writes an error response with the code matching the status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message, Code: statusCode(status)})
+}
+
+// writeServiceError writes the response of an error returned by a service,
+// prefixing its message with what the handler was doing. Errors of unknown
+// kind are logged and reported without their message, which may expose
+// database details.
+func writeServiceError(w http.ResponseWriter, r *http.Request, prefix string, err error) {
+	var serr *services.Error
+	if !errors.As(err, &serr) {
+		slog.ErrorContext(r.Context(), prefix, "error", err)
+		writeError(w, http.StatusInternalServerError, withPrefix(prefix, "internal error"))
+		return
+	}
+
+	status, code := http.StatusInternalServerError, codeInternal
+	for _, e := range serviceErrors {
+		if errors.Is(serr, e.kind) {
+			status, code = e.status, e.code
+			break
+		}
+	}
+
+	resp := errorResponse{Error: withPrefix(prefix, serr.Message), Code: code}
+	if serr.Field != "" {
+		resp.Details = []fieldDetail{{Field: serr.Field, Message: serr.Message}}
+	}
+	writeJSON(w, status, resp)
+}
+
+// statusCode returns the error code of an HTTP status.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeBadRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethod
+	case http.StatusConflict:
+		return codeConflict
+	default:
+		return codeInternal
+	}
+}
+
+func withPrefix(prefix, message string) string {
+	if prefix == "" {
+		return message
+	}
+	return prefix + ": " + message
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/p4u/padelfriends/events"
+)
+
+// sseKeepAlive is the interval of the comments sent to keep idle streams open.
+const sseKeepAlive = 25 * time.Second
+
+// EventsHandler streams group events to the browser.
+type EventsHandler struct {
+	Bus *events.Bus
+
+	mu      sync.Mutex
+	closing chan struct{}
+}
+
+// Shutdown ends the event streams, for the clients to resume from another
+// server with their last event ID. The HTTP server runs it on shutdown, as
+// it would otherwise wait for the streams until its timeout.
+func (h *EventsHandler) Shutdown() {
+	closing := h.closingChan()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-closing:
+	default:
+		close(closing)
+	}
+}
+
+func (h *EventsHandler) closingChan() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing == nil {
+		h.closing = make(chan struct{})
+	}
+	return h.closing
+}
+
+// GET /api/group/{name}/events
+// Server-Sent Events stream of the group events. Clients resume from the
+// Last-Event-ID header, or the lastEventId query parameter, after a reconnection.
+func (h *EventsHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = getQueryParam(r, "lastEventId")
+	}
+
+	sub, missed := h.Bus.SubscribeSince(groupName, lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, ev := range missed {
+		if err := writeSSE(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	closing := h.closingChan()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-sub.Events():
+			if !ok {
+				// Dropped by the bus; the client reconnects with its last ID
+				return
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes an event in the text/event-stream format.
+func writeSSE(w http.ResponseWriter, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
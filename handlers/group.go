@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/models"
 	"github.com/p4u/padelfriends/services"
 )
 
@@ -25,14 +25,9 @@ func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if payload.Name == "" || payload.Password == "" {
-		writeError(w, http.StatusBadRequest, "Missing name or password")
-		return
-	}
-
 	group, err := h.GroupService.CreateGroup(r.Context(), payload.Name, payload.Password)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to create group: "+err.Error())
+		writeServiceError(w, r, "Failed to create group", err)
 		return
 	}
 
@@ -41,32 +36,37 @@ func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 
 // GetGroupByName handles GET /api/group/byname/{name}
 func (h *GroupHandler) GetGroupByName(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
-	if name == "" {
+	if groupParam(r) == "" {
 		writeError(w, http.StatusBadRequest, "Missing group name")
 		return
 	}
 
-	g, err := h.GroupService.GetGroupByName(r.Context(), name)
+	g, err := loadGroup(r, h.GroupService)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "Group not found")
+		writeServiceError(w, r, "", err)
 		return
 	}
 
 	// Check password if provided for authentication status
-	password := getQueryParam(r, "password")
+	password := groupPassword(r)
 	if password != "" && services.CheckPassword(password, g.PasswordHash) {
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"name":            g.Name,
+			"slug":            g.Slug,
 			"created_at":      g.CreatedAt,
+			"settings":        g.Settings,
 			"isAuthenticated": true,
 		})
 		return
 	}
+	if password != "" {
+		authFailed(r)
+	}
 
 	// Return basic info for unauthenticated requests
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"name":            g.Name,
+		"slug":            g.Slug,
 		"created_at":      g.CreatedAt,
 		"isAuthenticated": false,
 	})
@@ -76,7 +76,7 @@ func (h *GroupHandler) GetGroupByName(w http.ResponseWriter, r *http.Request) {
 func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
 	groups, err := h.GroupService.ListGroups(r.Context())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Error listing groups: "+err.Error())
+		writeServiceError(w, r, "Error listing groups", err)
 		return
 	}
 
@@ -85,7 +85,7 @@ func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
 
 // AuthenticateGroup handles POST /api/group/{name}/authenticate
 func (h *GroupHandler) AuthenticateGroup(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
+	name := groupParam(r)
 	if name == "" {
 		writeError(w, http.StatusBadRequest, "Missing group name")
 		return
@@ -101,25 +101,52 @@ func (h *GroupHandler) AuthenticateGroup(w http.ResponseWriter, r *http.Request)
 
 	g, err := h.GroupService.GetGroupByName(r.Context(), name)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "Group not found")
+		writeServiceError(w, r, "", err)
 		return
 	}
 
 	if !services.CheckPassword(payload.Password, g.PasswordHash) {
+		authFailed(r)
 		writeError(w, http.StatusUnauthorized, "Invalid password")
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"name":            g.Name,
+		"slug":            g.Slug,
 		"created_at":      g.CreatedAt,
+		"settings":        g.Settings,
 		"isAuthenticated": true,
 	})
 }
 
+// UpdateSettings handles PUT /api/group/{name}/settings?password=SECRET
+// Payload: { "guest_stats": "exclude" | "mark" }
+func (h *GroupHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	name := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, name) {
+		return
+	}
+
+	var payload models.GroupSettings
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	settings, err := h.GroupService.UpdateSettings(r.Context(), name, payload)
+	if err != nil {
+		writeServiceError(w, r, "Error updating settings", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
 // ExportGroupMatchesCSV handles GET /api/group/{name}/export/csv
 func (h *GroupHandler) ExportGroupMatchesCSV(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
+	name := groupParam(r)
 	if name == "" {
 		writeError(w, http.StatusBadRequest, "Missing group name")
 		return
@@ -127,7 +154,7 @@ func (h *GroupHandler) ExportGroupMatchesCSV(w http.ResponseWriter, r *http.Requ
 
 	csv, err := h.GroupService.ExportGroupMatchesCSV(r.Context(), name)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Error exporting matches: "+err.Error())
+		writeServiceError(w, r, "Error exporting matches", err)
 		return
 	}
 
@@ -135,3 +162,41 @@ func (h *GroupHandler) ExportGroupMatchesCSV(w http.ResponseWriter, r *http.Requ
 	w.Header().Set("Content-Disposition", "attachment; filename="+name+"-matches.csv")
 	w.Write([]byte(csv))
 }
+
+// PatchGroup handles PATCH /api/v2/groups/{group}?password=SECRET
+// Payload: { "settings": { "guest_stats": "exclude" | "mark" } }
+func (h *GroupHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	name := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, name) {
+		return
+	}
+
+	var payload struct {
+		Settings *models.GroupSettings `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	g, err := loadGroup(r, h.GroupService)
+	if err != nil {
+		writeServiceError(w, r, "", err)
+		return
+	}
+	if payload.Settings != nil {
+		if g.Settings, err = h.GroupService.UpdateSettings(r.Context(), name, *payload.Settings); err != nil {
+			writeServiceError(w, r, "Error updating settings", err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":            g.Name,
+		"slug":            g.Slug,
+		"created_at":      g.CreatedAt,
+		"settings":        g.Settings,
+		"isAuthenticated": true,
+	})
+}
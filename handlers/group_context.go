@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/services"
+)
+
+// groupKey is the context key of the group resolved for a request.
+type groupKey struct{}
+
+// ResolveGroup loads the group named by the {group} slug of the v2 routes and
+// stores it in the request context, so that the handlers shared with the v1
+// API, which name groups by their {name}, work on both.
+func ResolveGroup(groupService *services.GroupService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g, err := groupService.GetGroupBySlug(r.Context(), chi.URLParam(r, "group"))
+			if err != nil {
+				writeServiceError(w, r, "", err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), groupKey{}, g)))
+		})
+	}
+}
+
+// requestGroup returns the group resolved by ResolveGroup, if any.
+func requestGroup(r *http.Request) (models.Group, bool) {
+	g, ok := r.Context().Value(groupKey{}).(models.Group)
+	return g, ok
+}
+
+// groupParam returns the name of the group of a request.
+func groupParam(r *http.Request) string {
+	if g, ok := requestGroup(r); ok {
+		return g.Name
+	}
+	return chi.URLParam(r, "name")
+}
+
+// loadGroup returns the group of a request, loading it by name on v1 routes.
+func loadGroup(r *http.Request, groupService *services.GroupService) (models.Group, error) {
+	if g, ok := requestGroup(r); ok {
+		return g, nil
+	}
+	return groupService.GetGroupByName(r.Context(), chi.URLParam(r, "name"))
+}
+
+// groupPassword returns the group password of a request, sent in the
+// X-Group-Password header or the password query parameter.
+func groupPassword(r *http.Request) string {
+	if p := r.Header.Get("X-Group-Password"); p != "" {
+		return p
+	}
+	return getQueryParam(r, "password")
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/p4u/padelfriends/buildinfo"
+	"github.com/p4u/padelfriends/migrations"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// HealthTimeout bounds the database ping of the health checks, below the
+// timeouts of the usual probes.
+const HealthTimeout = 2 * time.Second
+
+// HealthHandler reports whether the server is alive and ready to serve.
+type HealthHandler struct {
+	Client   *mongo.Client
+	Migrator *migrations.Migrator
+	Started  time.Time
+
+	// Token authenticates the detailed report, which is disabled when empty.
+	Token string
+
+	draining atomic.Bool
+}
+
+// HealthReport is the detailed health of the server.
+type HealthReport struct {
+	Status        string         `json:"status"` // "ok", "draining" or "unavailable"
+	Build         buildinfo.Info `json:"build"`
+	StartedAt     time.Time      `json:"started_at"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Database      DatabaseHealth `json:"database"`
+}
+
+// DatabaseHealth is the state of the MongoDB connection and schema.
+type DatabaseHealth struct {
+	Status            string  `json:"status"` // "ok" or "unavailable"
+	LatencyMS         float64 `json:"latency_ms"`
+	Error             string  `json:"error,omitempty"`
+	MigrationVersion  int     `json:"migration_version"`
+	PendingMigrations int     `json:"pending_migrations"`
+}
+
+// Drain makes the readiness checks fail from now on, so that the load
+// balancers stop sending requests before the server shuts down.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Livez handles GET /livez: the process serves requests, whatever the state
+// of the database.
+func (h *HealthHandler) Livez(w http.ResponseWriter, r *http.Request) {
+	writeText(w, http.StatusOK, "ok")
+}
+
+// Readyz handles GET /readyz and GET /api/health: the database answers and
+// the server is not shutting down.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeText(w, http.StatusServiceUnavailable, "draining")
+		return
+	}
+	if _, err := h.ping(r.Context()); err != nil {
+		slog.WarnContext(r.Context(), "health: database unavailable", "error", err)
+		writeText(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+	writeText(w, http.StatusOK, "ok")
+}
+
+// Details handles GET /api/health/details with the header
+// Authorization: Bearer TOKEN
+func (h *HealthHandler) Details(w http.ResponseWriter, r *http.Request) {
+	if h.Token == "" {
+		APINotFound(w, r)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	report := HealthReport{
+		Status:        "ok",
+		Build:         buildinfo.Get(),
+		StartedAt:     h.Started,
+		UptimeSeconds: int64(time.Since(h.Started) / time.Second),
+		Database:      DatabaseHealth{Status: "ok"},
+	}
+	latency, err := h.ping(r.Context())
+	report.Database.LatencyMS = float64(latency.Microseconds()) / 1000
+	if err == nil {
+		err = h.migrationStatus(r.Context(), &report.Database)
+	}
+	if err != nil {
+		report.Status = "unavailable"
+		report.Database.Status = "unavailable"
+		report.Database.Error = err.Error()
+	}
+	if h.draining.Load() {
+		report.Status = "draining"
+	}
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// ping checks that the database answers within HealthTimeout and returns
+// how long it took.
+func (h *HealthHandler) ping(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+	start := time.Now()
+	err := h.Client.Ping(ctx, nil)
+	return time.Since(start), err
+}
+
+// migrationStatus sets the latest applied migration and the pending ones.
+func (h *HealthHandler) migrationStatus(ctx context.Context, db *DatabaseHealth) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	defer cancel()
+	status, err := h.Migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			db.PendingMigrations++
+		} else if s.Version > db.MigrationVersion {
+			db.MigrationVersion = s.Version
+		}
+	}
+	return nil
+}
+
+func writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
 
 	"github.com/p4u/padelfriends/services"
@@ -13,17 +14,11 @@ func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		// If encoding fails, log the error and write a generic error message
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		// The status is already sent, only log the failure
+		slog.Error("cannot encode response", "error", err)
 	}
 }
 
-// writeError writes an error message in JSON format to the response writer.
-func writeError(w http.ResponseWriter, status int, message string) {
-	response := map[string]string{"error": message}
-	writeJSON(w, status, response)
-}
-
 // parseObjectID parses a string into a MongoDB ObjectID or returns an error.
 func parseObjectID(s string) (primitive.ObjectID, error) {
 	return primitive.ObjectIDFromHex(s)
@@ -36,22 +31,68 @@ func getQueryParam(r *http.Request, key string) string {
 
 // checkGroupPassword checks if the provided password matches the group’s password.
 func checkGroupPassword(w http.ResponseWriter, r *http.Request, groupService *services.GroupService, groupName string) bool {
-	password := getQueryParam(r, "password")
+	password := groupPassword(r)
 	if password == "" {
-		http.Error(w, "Missing password query parameter", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Missing password query parameter")
 		return false
 	}
 
-	// Retrieve group by Name
-	g, err := groupService.GetGroupByName(r.Context(), groupName)
+	// Retrieve group by Name, unless already resolved by ResolveGroup
+	g, ok := requestGroup(r)
+	var err error
+	if !ok || g.Name != groupName {
+		g, err = groupService.GetGroupByName(r.Context(), groupName)
+	}
 	if err != nil {
-		http.Error(w, "Group not found or error retrieving group", http.StatusNotFound)
+		writeServiceError(w, r, "", err)
 		return false
 	}
 
 	valid := services.CheckPassword(password, g.PasswordHash)
 	if !valid {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		authFailed(r)
+		writeError(w, http.StatusUnauthorized, "Invalid password")
+		return false
+	}
+	return true
+}
+
+// RequireAuth rejects the requests of group routes without a group password.
+// Handlers still check the password with checkGroupPassword.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if groupPassword(r) == "" {
+			writeError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		if groupParam(r) == "" {
+			writeError(w, http.StatusBadRequest, "Group name required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isGroupMember reports whether the request carries a valid group password,
+// without writing any response.
+func isGroupMember(r *http.Request, groupService *services.GroupService, groupName string) bool {
+	password := groupPassword(r)
+	if password == "" {
+		return false
+	}
+
+	g, ok := requestGroup(r)
+	var err error
+	if !ok || g.Name != groupName {
+		g, err = groupService.GetGroupByName(r.Context(), groupName)
+	}
+	if err != nil {
+		return false
+	}
+	if !services.CheckPassword(password, g.PasswordHash) {
+		authFailed(r)
 		return false
 	}
 	return true
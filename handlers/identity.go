@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdentityHandler handles the global player identity requests.
+type IdentityHandler struct {
+	GroupService    *services.GroupService
+	IdentityService *services.IdentityService
+	MatchService    *services.MatchService
+	StatsService    *services.StatsService
+}
+
+// CreateIdentity handles POST /api/identity
+// Payload: { "name": "PlayerName", "password": "secret" }
+func (h *IdentityHandler) CreateIdentity(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	identity, err := h.IdentityService.CreateIdentity(r.Context(), payload.Name, payload.Password)
+	if err != nil {
+		writeServiceError(w, r, "Failed to create identity", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, identity)
+}
+
+// GetIdentity handles GET /api/identity/{identity_id}?password=SECRET
+// Returns the identity together with the group players linked to it.
+func (h *IdentityHandler) GetIdentity(w http.ResponseWriter, r *http.Request) {
+	identity, players, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         identity.ID,
+		"name":       identity.Name,
+		"created_at": identity.CreatedAt,
+		"players":    players,
+	})
+}
+
+// ListMatches handles GET /api/identity/{identity_id}/matches?password=SECRET
+// Returns the matches of all linked players across their groups.
+func (h *IdentityHandler) ListMatches(w http.ResponseWriter, r *http.Request) {
+	_, players, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	playerIDs := make([]primitive.ObjectID, 0, len(players))
+	for _, p := range players {
+		playerIDs = append(playerIDs, p.ID)
+	}
+
+	matches, err := h.MatchService.ListPlayerMatches(r.Context(), playerIDs)
+	if err != nil {
+		writeServiceError(w, r, "Error listing matches", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// GetStatistics handles GET /api/identity/{identity_id}/statistics?password=SECRET
+// Returns the per group and combined statistics of the identity.
+func (h *IdentityHandler) GetStatistics(w http.ResponseWriter, r *http.Request) {
+	identity, players, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := h.StatsService.ComputeIdentityStats(r.Context(), identity, players)
+	if err != nil {
+		writeServiceError(w, r, "Error computing statistics", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// UnlinkPlayer handles DELETE /api/identity/{identity_id}/players/{player_id}?password=SECRET
+func (h *IdentityHandler) UnlinkPlayer(w http.ResponseWriter, r *http.Request) {
+	identity, _, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	if err := h.IdentityService.UnlinkIdentityPlayer(r.Context(), identity.ID, playerID); err != nil {
+		writeServiceError(w, r, "Error unlinking player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unlinked"})
+}
+
+// LinkGroupPlayer handles POST /api/group/{name}/players/{player_id}/link?password=SECRET
+// Payload: { "identity_id": "identityID", "identity_password": "secret" }
+// Linking requires both the group password and the identity password.
+func (h *IdentityHandler) LinkGroupPlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	var payload struct {
+		IdentityID       string `json:"identity_id"`
+		IdentityPassword string `json:"identity_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	identityID, err := parseObjectID(payload.IdentityID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	if _, err := h.IdentityService.Authenticate(r.Context(), identityID, payload.IdentityPassword); err != nil {
+		if errors.Is(err, services.ErrUnauthorized) {
+			authFailed(r)
+		}
+		writeServiceError(w, r, "", err)
+		return
+	}
+
+	if err := h.IdentityService.LinkPlayer(r.Context(), groupName, playerID, identityID); err != nil {
+		writeServiceError(w, r, "Error linking player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "linked"})
+}
+
+// UnlinkGroupPlayer handles POST /api/group/{name}/players/{player_id}/unlink?password=SECRET
+func (h *IdentityHandler) UnlinkGroupPlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	if err := h.IdentityService.UnlinkPlayer(r.Context(), groupName, playerID); err != nil {
+		writeServiceError(w, r, "Error unlinking player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unlinked"})
+}
+
+// authenticate checks the identity password query parameter and loads the
+// linked players. It writes the error response and returns false on failure.
+func (h *IdentityHandler) authenticate(w http.ResponseWriter, r *http.Request) (models.Identity, []models.Player, bool) {
+	identityID, err := parseObjectID(chi.URLParam(r, "identity_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid identity ID")
+		return models.Identity{}, nil, false
+	}
+
+	password := getQueryParam(r, "password")
+	if password == "" {
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return models.Identity{}, nil, false
+	}
+
+	identity, err := h.IdentityService.Authenticate(r.Context(), identityID, password)
+	if err != nil {
+		if errors.Is(err, services.ErrUnauthorized) {
+			authFailed(r)
+		}
+		writeServiceError(w, r, "", err)
+		return models.Identity{}, nil, false
+	}
+
+	players, err := h.IdentityService.LinkedPlayers(r.Context(), identity.ID)
+	if err != nil {
+		writeServiceError(w, r, "Error listing linked players", err)
+		return models.Identity{}, nil, false
+	}
+
+	return identity, players, true
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/p4u/padelfriends/live"
+	"github.com/p4u/padelfriends/scoring"
+	"github.com/p4u/padelfriends/services"
+)
+
+const (
+	liveWriteWait  = 10 * time.Second
+	livePongWait   = 60 * time.Second
+	livePingPeriod = livePongWait * 9 / 10
+	liveMaxMessage = 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// LiveHandler serves the point-by-point scoring sessions over WebSocket.
+type LiveHandler struct {
+	GroupService *services.GroupService
+	MatchService *services.MatchService
+	Hub          *live.Hub
+}
+
+// GET /api/group/{name}/matches/{match_id}/live?password=SECRET&sets=1&games=6&golden_point=false&tie_break=7
+// WebSocket endpoint of the live score of a pending match. Anyone can follow
+// the score; only clients providing the group password can send commands:
+// { "type": "point", "team": 1 }, { "type": "undo" } and { "type": "finalize" }.
+// The format parameters only apply when the session is started.
+func (h *LiveHandler) ServeLive(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	matchID, err := parseObjectID(chi.URLParam(r, "match_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	match, err := h.MatchService.GetMatch(r.Context(), groupName, matchID)
+	if err != nil {
+		writeServiceError(w, r, "", err)
+		return
+	}
+	if match.Status != "pending" {
+		writeError(w, http.StatusConflict, "Match is not pending")
+		return
+	}
+
+	format, err := parseScoringFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	scorer := isGroupMember(r, h.GroupService, groupName)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied to the client
+		return
+	}
+	defer conn.Close()
+
+	session, client, err := h.Hub.Join(groupName, matchID, format)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()))
+		return
+	}
+	defer client.Leave()
+
+	replies := make(chan []byte, 4)
+	done := make(chan struct{})
+	go writeLive(conn, session.Snapshot(), client.Send(), replies, done)
+	defer close(done)
+
+	conn.SetReadLimit(liveMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+
+	for {
+		var cmd live.Command
+		if err := conn.ReadJSON(&cmd); err != nil {
+			return
+		}
+
+		var cmdErr error
+		if !scorer {
+			cmdErr = errors.New("authentication required to keep the score")
+		} else {
+			cmdErr = session.Apply(r.Context(), cmd)
+		}
+		if cmdErr != nil {
+			select {
+			case replies <- session.ErrorMessage(cmdErr):
+			default:
+			}
+		}
+	}
+}
+
+// writeLive sends the session messages and the command errors to the
+// connection, and pings it to detect dead peers.
+func writeLive(conn *websocket.Conn, first []byte, updates <-chan []byte, replies <-chan []byte, done <-chan struct{}) {
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+
+	write := func(msgType int, data []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+		return conn.WriteMessage(msgType, data) == nil
+	}
+
+	if !write(websocket.TextMessage, first) {
+		return
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-updates:
+			if !ok {
+				write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				conn.Close()
+				return
+			}
+			if !write(websocket.TextMessage, msg) {
+				return
+			}
+		case msg := <-replies:
+			if !write(websocket.TextMessage, msg) {
+				return
+			}
+		case <-ping.C:
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		}
+	}
+}
+
+// parseScoringFormat reads the match format query parameters.
+func parseScoringFormat(r *http.Request) (scoring.Format, error) {
+	format := scoring.DefaultFormat
+	intParams := map[string]*int{
+		"sets":      &format.Sets,
+		"games":     &format.GamesPerSet,
+		"tie_break": &format.TieBreakPoints,
+	}
+	for name, dst := range intParams {
+		if v := getQueryParam(r, name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return scoring.Format{}, errors.New("invalid " + name + " parameter")
+			}
+			*dst = n
+		}
+	}
+	format.GoldenPoint = getQueryParam(r, "golden_point") == "true"
+	return format, format.Validate()
+}
@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/p4u/padelfriends/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// maxIdempotencyKeyLength bounds the Idempotency-Key header of batch requests.
+const maxIdempotencyKeyLength = 255
+
 type MatchHandler struct {
 	GroupService *services.GroupService
 	MatchService *services.MatchService
@@ -18,7 +23,7 @@ type MatchHandler struct {
 // POST /api/group/{name}/matches?password=SECRET
 // Payload: { "player_ids": ["playerID1","playerID2","playerID3","playerID4"] }
 func (h *MatchHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 
 	if !checkGroupPassword(w, r, h.GroupService, groupName) {
 		return
@@ -28,11 +33,11 @@ func (h *MatchHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
 		PlayerIDs []string `json:"player_ids"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 	if len(payload.PlayerIDs) != 4 {
-		http.Error(w, "Exactly 4 player IDs required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Exactly 4 player IDs required")
 		return
 	}
 
@@ -40,7 +45,7 @@ func (h *MatchHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
 	for _, pid := range payload.PlayerIDs {
 		objID, err := parseObjectID(pid)
 		if err != nil {
-			http.Error(w, "Invalid player ID: "+pid, http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Invalid player ID: "+pid)
 			return
 		}
 		pids = append(pids, objID)
@@ -48,7 +53,7 @@ func (h *MatchHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
 
 	match, err := h.MatchService.CreateMatch(r.Context(), groupName, pids)
 	if err != nil {
-		http.Error(w, "Error creating match: "+err.Error(), http.StatusBadRequest)
+		writeServiceError(w, r, "Error creating match", err)
 		return
 	}
 
@@ -57,25 +62,33 @@ func (h *MatchHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
 
 // POST /api/group/{name}/matches/batch?password=SECRET
 // Payload: { "matches": [["playerID1","playerID2","playerID3","playerID4"], [...], ...] }
+// Either all matches are created or none. Retries of a request with the same
+// Idempotency-Key header return the matches created by the first one.
 func (h *MatchHandler) CreateMatches(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 
 	if !checkGroupPassword(w, r, h.GroupService, groupName) {
 		return
 	}
 
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, "Idempotency-Key too long")
+		return
+	}
+
 	var payload struct {
 		Matches [][]string `json:"matches"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 
 	var allMatches [][]primitive.ObjectID
 	for _, match := range payload.Matches {
 		if len(match) != 4 {
-			http.Error(w, "Each match must have exactly 4 players", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Each match must have exactly 4 players")
 			return
 		}
 
@@ -83,7 +96,7 @@ func (h *MatchHandler) CreateMatches(w http.ResponseWriter, r *http.Request) {
 		for _, pid := range match {
 			objID, err := parseObjectID(pid)
 			if err != nil {
-				http.Error(w, "Invalid player ID: "+pid, http.StatusBadRequest)
+				writeError(w, http.StatusBadRequest, "Invalid player ID: "+pid)
 				return
 			}
 			pids = append(pids, objID)
@@ -91,18 +104,23 @@ func (h *MatchHandler) CreateMatches(w http.ResponseWriter, r *http.Request) {
 		allMatches = append(allMatches, pids)
 	}
 
-	matches, err := h.MatchService.CreateMatches(r.Context(), groupName, allMatches)
+	matches, replayed, err := h.MatchService.CreateMatches(r.Context(), groupName, allMatches, idempotencyKey)
 	if err != nil {
-		http.Error(w, "Error creating matches: "+err.Error(), http.StatusBadRequest)
+		writeServiceError(w, r, "Error creating matches", err)
 		return
 	}
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
 
 	writeJSON(w, http.StatusCreated, matches)
 }
 
 // POST /api/group/{name}/matches/{match_id}/cancel?password=SECRET
+// The match updates accept an If-Match header with the ETag of the match and
+// fail with 409 Conflict if it was modified since.
 func (h *MatchHandler) CancelMatch(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 	matchIDStr := chi.URLParam(r, "match_id")
 
 	if !checkGroupPassword(w, r, h.GroupService, groupName) {
@@ -111,12 +129,17 @@ func (h *MatchHandler) CancelMatch(w http.ResponseWriter, r *http.Request) {
 
 	matchID, err := parseObjectID(matchIDStr)
 	if err != nil {
-		http.Error(w, "Invalid match ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
 		return
 	}
 
-	if err := h.MatchService.CancelMatch(r.Context(), matchID); err != nil {
-		http.Error(w, "Error cancelling match: "+err.Error(), http.StatusBadRequest)
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.MatchService.CancelMatch(r.Context(), groupName, matchID, version); err != nil {
+		writeServiceError(w, r, "Error cancelling match", err)
 		return
 	}
 
@@ -126,7 +149,7 @@ func (h *MatchHandler) CancelMatch(w http.ResponseWriter, r *http.Request) {
 // POST /api/group/{name}/matches/{match_id}/results?password=SECRET
 // Payload: { "score_team1": X, "score_team2": Y }
 func (h *MatchHandler) SubmitResults(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 	matchIDStr := chi.URLParam(r, "match_id")
 
 	if !checkGroupPassword(w, r, h.GroupService, groupName) {
@@ -135,7 +158,12 @@ func (h *MatchHandler) SubmitResults(w http.ResponseWriter, r *http.Request) {
 
 	matchID, err := parseObjectID(matchIDStr)
 	if err != nil {
-		http.Error(w, "Invalid match ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
 		return
 	}
 
@@ -144,28 +172,72 @@ func (h *MatchHandler) SubmitResults(w http.ResponseWriter, r *http.Request) {
 		ScoreTeam2 int `json:"score_team2"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 
-	if err := h.MatchService.SubmitResults(r.Context(), matchID, payload.ScoreTeam1, payload.ScoreTeam2); err != nil {
-		http.Error(w, "Error submitting results: "+err.Error(), http.StatusBadRequest)
+	if err := h.MatchService.SubmitResults(r.Context(), groupName, matchID, version, payload.ScoreTeam1, payload.ScoreTeam2); err != nil {
+		writeServiceError(w, r, "Error submitting results", err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
+// POST /api/group/{name}/matches/{match_id}/dispute?password=SECRET
+// Payload: { "reason": "The score was 6-4, not 4-6" }
+func (h *MatchHandler) DisputeResult(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+	matchIDStr := chi.URLParam(r, "match_id")
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	matchID, err := parseObjectID(matchIDStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if err := h.MatchService.DisputeResult(r.Context(), groupName, matchID, version, payload.Reason); err != nil {
+		writeServiceError(w, r, "Error disputing result", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "disputed"})
+}
+
 // GET /api/group/{name}/matches?page=1&pageSize=10
+// Accepts the filters of ListMatchesPage. With recent=true it returns the
+// latest matches as a plain list instead.
 func (h *MatchHandler) ListMatches(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
+
+	filter, ok := matchFilter(w, r)
+	if !ok {
+		return
+	}
 
 	// Check if we want recent matches or paginated list
 	wantRecent := r.URL.Query().Get("recent") == "true"
 	if wantRecent {
-		matches, err := h.MatchService.GetRecentMatches(r.Context(), groupName)
+		matches, _, err := h.MatchService.ListMatches(r.Context(), groupName, filter, "", services.DefaultPageSize)
 		if err != nil {
-			http.Error(w, "Error listing matches: "+err.Error(), http.StatusInternalServerError)
+			writeServiceError(w, r, "Error listing matches", err)
 			return
 		}
 		writeJSON(w, http.StatusOK, matches)
@@ -182,10 +254,23 @@ func (h *MatchHandler) ListMatches(w http.ResponseWriter, r *http.Request) {
 	if pageSize < 1 {
 		pageSize = 10
 	}
+	if pageSize > services.MaxPageSize {
+		pageSize = services.MaxPageSize
+	}
 
-	matches, total, err := h.MatchService.ListMatches(r.Context(), groupName, page, pageSize)
+	total, err := h.MatchService.CountMatches(r.Context(), groupName, filter)
+	if err != nil {
+		writeServiceError(w, r, "Error listing matches", err)
+		return
+	}
+	cursor, err := h.MatchService.PageCursor(r.Context(), groupName, filter, page, pageSize)
+	if err != nil {
+		writeServiceError(w, r, "Error listing matches", err)
+		return
+	}
+	matches, _, err := h.MatchService.ListMatches(r.Context(), groupName, filter, cursor, pageSize)
 	if err != nil {
-		http.Error(w, "Error listing matches: "+err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, r, "Error listing matches", err)
 		return
 	}
 
@@ -199,3 +284,234 @@ func (h *MatchHandler) ListMatches(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, response)
 }
+
+// GET /api/v2/groups/{group}/matches?cursor=...&limit=20
+// Filters: status=pending|completed, player=playerID, partners=playerID,playerID,
+// from=2024-01-01, to=2024-07-01 (exclusive, dates or RFC 3339 times) and season=2024.
+// Lists the matches of a group, newest first. The next_cursor of the response
+// fetches the following page and is omitted on the last one.
+func (h *MatchHandler) ListMatchesPage(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	limit := 0
+	if v := getQueryParam(r, "limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	filter, ok := matchFilter(w, r)
+	if !ok {
+		return
+	}
+
+	matches, next, err := h.MatchService.ListMatches(r.Context(), groupName, filter, getQueryParam(r, "cursor"), limit)
+	if err != nil {
+		writeServiceError(w, r, "Error listing matches", err)
+		return
+	}
+
+	response := map[string]interface{}{"matches": matches}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// matchFilter parses the filter query parameters of the match listings. It
+// writes the error response and returns false on failure.
+func matchFilter(w http.ResponseWriter, r *http.Request) (services.MatchFilter, bool) {
+	filter := services.MatchFilter{Status: getQueryParam(r, "status")}
+
+	if v := getQueryParam(r, "player"); v != "" {
+		playerID, err := parseObjectID(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid player ID")
+			return filter, false
+		}
+		filter.PlayerID = &playerID
+	}
+
+	if v := getQueryParam(r, "partners"); v != "" {
+		for _, pid := range strings.Split(v, ",") {
+			playerID, err := parseObjectID(pid)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid player ID: "+pid)
+				return filter, false
+			}
+			filter.Partners = append(filter.Partners, playerID)
+		}
+	}
+
+	for _, bound := range []struct {
+		param string
+		t     *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		v := getQueryParam(r, bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, v)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid "+bound.param+" date")
+			return filter, false
+		}
+		*bound.t = t
+	}
+
+	if v := getQueryParam(r, "season"); v != "" {
+		season, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid season")
+			return filter, false
+		}
+		filter.Season = season
+	}
+	return filter, true
+}
+
+// GET /api/v2/groups/{group}/matches/{match_id}
+// The ETag of the response is the version of the match.
+func (h *MatchHandler) GetMatch(w http.ResponseWriter, r *http.Request) {
+	matchID, err := parseObjectID(chi.URLParam(r, "match_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	match, err := h.MatchService.GetMatchResponse(r.Context(), groupParam(r), matchID)
+	if err != nil {
+		writeServiceError(w, r, "Error loading match", err)
+		return
+	}
+
+	etag := matchETag(match.Version)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, match)
+}
+
+// PATCH /api/v2/groups/{group}/matches/{match_id}?password=SECRET
+// Payload: { "score_team1": X, "score_team2": Y } to submit the result, or
+// { "disputed": true, "dispute_reason": "..." } to dispute it.
+// Send the ETag of the match in If-Match to reject the update with 409 Conflict
+// if someone else changed the match meanwhile.
+func (h *MatchHandler) PatchMatch(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	matchID, err := parseObjectID(chi.URLParam(r, "match_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		ScoreTeam1    *int   `json:"score_team1"`
+		ScoreTeam2    *int   `json:"score_team2"`
+		Disputed      bool   `json:"disputed"`
+		DisputeReason string `json:"dispute_reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if _, err := h.MatchService.GetMatch(r.Context(), groupName, matchID); err != nil {
+		writeServiceError(w, r, "", err)
+		return
+	}
+
+	switch {
+	case payload.ScoreTeam1 != nil && payload.ScoreTeam2 != nil:
+		err = h.MatchService.SubmitResults(r.Context(), groupName, matchID, version, *payload.ScoreTeam1, *payload.ScoreTeam2)
+	case payload.Disputed:
+		err = h.MatchService.DisputeResult(r.Context(), groupName, matchID, version, payload.DisputeReason)
+	default:
+		writeError(w, http.StatusBadRequest, "Payload must set both scores or disputed")
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, "Error updating match", err)
+		return
+	}
+
+	match, err := h.MatchService.GetMatchResponse(r.Context(), groupName, matchID)
+	if err != nil {
+		writeServiceError(w, r, "Error loading match", err)
+		return
+	}
+	w.Header().Set("ETag", matchETag(match.Version))
+	writeJSON(w, http.StatusOK, match)
+}
+
+// DELETE /api/v2/groups/{group}/matches/{match_id}?password=SECRET
+// Cancels a pending match.
+func (h *MatchHandler) DeleteMatch(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	matchID, err := parseObjectID(chi.URLParam(r, "match_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid match ID")
+		return
+	}
+
+	if _, err := h.MatchService.GetMatch(r.Context(), groupName, matchID); err != nil {
+		writeServiceError(w, r, "", err)
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.MatchService.CancelMatch(r.Context(), groupName, matchID, version); err != nil {
+		writeServiceError(w, r, "Error cancelling match", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// matchETag returns the entity tag of a match version.
+func matchETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion returns the match version required by the If-Match header,
+// or services.AnyVersion when there is none. It writes the error response and
+// returns false on failure.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	etag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if etag == "" || etag == "*" {
+		return services.AnyVersion, true
+	}
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(etag, `"`), `"`))
+	if err != nil || version < 0 || !strings.HasPrefix(etag, `"`) {
+		writeError(w, http.StatusBadRequest, "Invalid If-Match header")
+		return 0, false
+	}
+	return version, true
+}
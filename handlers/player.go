@@ -2,57 +2,358 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/models"
 	"github.com/p4u/padelfriends/services"
 )
 
 type PlayerHandler struct {
 	GroupService  *services.GroupService
 	PlayerService *services.PlayerService
+	AvatarStore   *services.AvatarStore
 }
 
 // POST /api/group/{name}/players?password=SECRET
-// Payload: { "name": "PlayerName" }
+// Payload: { "name": "PlayerName", "guest": false }
 func (h *PlayerHandler) AddPlayer(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 
 	if !checkGroupPassword(w, r, h.GroupService, groupName) {
 		return
 	}
 
+	var payload struct {
+		Name  string `json:"name"`
+		Guest bool   `json:"guest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	player, err := h.PlayerService.AddPlayer(r.Context(), groupName, payload.Name, payload.Guest)
+	if err != nil {
+		writeServiceError(w, r, "Error adding player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, player)
+}
+
+// GET /api/group/{name}/players?all=true
+// Deactivated players are only listed when all=true. Contact information is
+// only included when a valid group password is provided.
+func (h *PlayerHandler) ListPlayers(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+	includeInactive := getQueryParam(r, "all") == "true"
+
+	players, err := h.PlayerService.ListPlayers(r.Context(), groupName, includeInactive)
+	if err != nil {
+		writeServiceError(w, r, "Error listing players", err)
+		return
+	}
+
+	if !isGroupMember(r, h.GroupService, groupName) {
+		for i := range players {
+			players[i].Contact = nil
+		}
+	}
+
+	writeJSON(w, http.StatusOK, players)
+}
+
+// PUT /api/group/{name}/players/{player_id}?password=SECRET
+// Payload: { "name": "NewName" }
+func (h *PlayerHandler) RenamePlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
 	var payload struct {
 		Name string `json:"name"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	player, err := h.PlayerService.RenamePlayer(r.Context(), groupName, playerID, payload.Name)
+	if err != nil {
+		writeServiceError(w, r, "Error renaming player", err)
 		return
 	}
 
-	if payload.Name == "" {
-		http.Error(w, "Player name is required", http.StatusBadRequest)
+	writeJSON(w, http.StatusOK, player)
+}
+
+// POST /api/group/{name}/players/{player_id}/deactivate?password=SECRET
+func (h *PlayerHandler) DeactivatePlayer(w http.ResponseWriter, r *http.Request) {
+	h.setPlayerActive(w, r, false)
+}
+
+// POST /api/group/{name}/players/{player_id}/activate?password=SECRET
+func (h *PlayerHandler) ActivatePlayer(w http.ResponseWriter, r *http.Request) {
+	h.setPlayerActive(w, r, true)
+}
+
+func (h *PlayerHandler) setPlayerActive(w http.ResponseWriter, r *http.Request, active bool) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
 		return
 	}
 
-	player, err := h.PlayerService.AddPlayer(r.Context(), groupName, payload.Name)
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
 	if err != nil {
-		http.Error(w, "Error adding player: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, player)
+	player, err := h.PlayerService.SetPlayerActive(r.Context(), groupName, playerID, active)
+	if err != nil {
+		writeServiceError(w, r, "Error updating player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, player)
 }
 
-// GET /api/group/{name}/players
-func (h *PlayerHandler) ListPlayers(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+// DELETE /api/group/{name}/players/{player_id}?password=SECRET
+func (h *PlayerHandler) DeletePlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
 
-	players, err := h.PlayerService.ListPlayers(r.Context(), groupName)
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
 	if err != nil {
-		http.Error(w, "Error listing players: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, players)
+	if err := h.PlayerService.DeletePlayer(r.Context(), groupName, playerID); err != nil {
+		writeServiceError(w, r, "Error deleting player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// POST /api/group/{name}/players/{player_id}/merge?password=SECRET
+// Payload: { "into": "canonicalPlayerID" }
+// Moves all matches of player_id to the canonical player and removes player_id.
+func (h *PlayerHandler) MergePlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	duplicateID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	var payload struct {
+		Into string `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	canonicalID, err := parseObjectID(payload.Into)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID: "+payload.Into)
+		return
+	}
+
+	merged, err := h.PlayerService.MergePlayers(r.Context(), groupName, duplicateID, canonicalID)
+	if err != nil {
+		writeServiceError(w, r, "Error merging players", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":          "merged",
+		"matches_updated": merged,
+	})
+}
+
+// PUT /api/group/{name}/players/{player_id}/attributes?password=SECRET
+// Payload: { "preferred_side": "drive|reves|both", "handedness": "right|left", "level": 3.5,
+// "contact": { "email": "...", "phone": "..." } }
+func (h *PlayerHandler) UpdateAttributes(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	var payload models.PlayerAttributes
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	player, err := h.PlayerService.UpdateAttributes(r.Context(), groupName, playerID, payload)
+	if err != nil {
+		writeServiceError(w, r, "Error updating player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, player)
+}
+
+// POST /api/group/{name}/players/{player_id}/avatar?password=SECRET
+// Multipart form with the image in the "avatar" field (JPEG, PNG, WebP or GIF, up to 2 MiB).
+func (h *PlayerHandler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxAvatarSize+64<<10)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Missing or too large avatar file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxAvatarSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Error reading avatar file")
+		return
+	}
+
+	if _, err := h.PlayerService.GetPlayer(r.Context(), groupName, playerID); err != nil {
+		writeServiceError(w, r, "Error updating player", err)
+		return
+	}
+
+	avatar, err := h.AvatarStore.Save(playerID, data)
+	if err != nil {
+		writeServiceError(w, r, "Error storing avatar", err)
+		return
+	}
+
+	previous, err := h.PlayerService.SetAvatar(r.Context(), groupName, playerID, avatar)
+	if err != nil {
+		writeServiceError(w, r, "Error updating player", err)
+		return
+	}
+	if previous != "" && previous != avatar {
+		h.AvatarStore.Remove(previous)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"avatar": avatar})
+}
+
+// GET /api/group/{name}/players/{player_id}/avatar
+func (h *PlayerHandler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	player, err := h.PlayerService.GetPlayer(r.Context(), groupName, playerID)
+	if err != nil || player.Avatar == "" {
+		writeError(w, http.StatusNotFound, "Avatar not found")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeFile(w, r, h.AvatarStore.Path(player.Avatar))
+}
+
+// GET /api/v2/groups/{group}/players/{player_id}
+// Contact information is only included when a valid group password is provided.
+func (h *PlayerHandler) GetPlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	player, err := h.PlayerService.GetPlayer(r.Context(), groupName, playerID)
+	if err != nil {
+		writeServiceError(w, r, "Error loading player", err)
+		return
+	}
+
+	if !isGroupMember(r, h.GroupService, groupName) {
+		player.Contact = nil
+	}
+
+	writeJSON(w, http.StatusOK, player)
+}
+
+// PATCH /api/v2/groups/{group}/players/{player_id}
+// Payload: { "name": "NewName", "inactive": false, "attributes": { ... } }, every field optional.
+func (h *PlayerHandler) PatchPlayer(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	playerID, err := parseObjectID(chi.URLParam(r, "player_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid player ID")
+		return
+	}
+
+	var payload struct {
+		Name       *string                  `json:"name"`
+		Inactive   *bool                    `json:"inactive"`
+		Attributes *models.PlayerAttributes `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	player, err := h.PlayerService.UpdatePlayer(r.Context(), groupName, playerID, services.PlayerUpdate{
+		Name:       payload.Name,
+		Inactive:   payload.Inactive,
+		Attributes: payload.Attributes,
+	})
+	if err != nil {
+		writeServiceError(w, r, "Error updating player", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, player)
 }
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/ratelimit"
+)
+
+type authAttemptKey struct{}
+
+// authAttempt is the client and scope of a request carrying a password, for
+// the handlers to report a wrong one with authFailed.
+type authAttempt struct {
+	limiter *ratelimit.Limiter
+	client  string
+	scope   string
+}
+
+// RateLimit throttles the requests carrying a password, and those to the
+// authenticate endpoints, per client and per group or identity. Locked out
+// and throttled clients get 429 Too Many Requests with Retry-After. The
+// client is the address of the connection, or the last X-Forwarded-For
+// address when trustProxy is set, as appended by a reverse proxy.
+func RateLimit(limiter *ratelimit.Limiter, trustProxy bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if groupPassword(r) == "" && !strings.HasSuffix(r.URL.Path, "/authenticate") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			attempt := authAttempt{limiter: limiter, client: clientIP(r, trustProxy), scope: authScope(r)}
+			if wait := limiter.Allow(attempt.client, attempt.scope); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "Too many attempts, retry later")
+				return
+			}
+			ctx := context.WithValue(r.Context(), authAttemptKey{}, attempt)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authFailed reports a wrong password to the rate limiter of the request,
+// if any, which may lock the client out.
+func authFailed(r *http.Request) {
+	attempt, ok := r.Context().Value(authAttemptKey{}).(authAttempt)
+	if !ok {
+		return
+	}
+	attempt.limiter.Fail(r.Context(), attempt.client, attempt.scope)
+}
+
+// authScope names the group or identity whose password a request tries.
+func authScope(r *http.Request) string {
+	if name := groupParam(r); name != "" {
+		return "group:" + name
+	}
+	if id := chi.URLParam(r, "identity_id"); id != "" {
+		return "identity:" + id
+	}
+	return ""
+}
+
+// clientIP returns the address of the client of a request. IPv6 clients are
+// identified by their /64 prefix, since a single host is usually given the
+// whole prefix and could otherwise change address at each attempt.
+func clientIP(r *http.Request, trustProxy bool) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+	if trustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			hops := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(ip) != nil {
+				host = ip
+			}
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return host
+}
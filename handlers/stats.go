@@ -3,7 +3,6 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/p4u/padelfriends/services"
 )
 
@@ -14,11 +13,11 @@ type StatsHandler struct {
 
 // GET /api/group/{name}/statistics
 func (h *StatsHandler) GetStatistics(w http.ResponseWriter, r *http.Request) {
-	groupName := chi.URLParam(r, "name")
+	groupName := groupParam(r)
 
 	stats, err := h.StatsService.ComputeStats(r.Context(), groupName)
 	if err != nil {
-		http.Error(w, "Error computing statistics: "+err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, r, "Error computing statistics", err)
 		return
 	}
 
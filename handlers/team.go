@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TeamHandler struct {
+	GroupService *services.GroupService
+	TeamService  *services.TeamService
+}
+
+// POST /api/group/{name}/teams/suggest
+// Payload: { "player_ids": ["playerID1","playerID2","playerID3","playerID4"] }
+// Returns the possible team splits, the most balanced first.
+func (h *TeamHandler) SuggestTeams(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	var payload struct {
+		PlayerIDs []string `json:"player_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	pids, ok := parsePlayerIDs(w, payload.PlayerIDs)
+	if !ok {
+		return
+	}
+
+	suggestions, err := h.TeamService.SuggestTeams(r.Context(), groupName, pids)
+	if err != nil {
+		writeServiceError(w, r, "Error suggesting teams", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, suggestions)
+}
+
+// POST /api/group/{name}/matches/generate?password=SECRET
+// Payload: { "player_ids": ["playerID1", ...], "count": 3 }
+// Proposes balanced matches without creating them; each proposal can be passed
+// to the batch creation endpoint as team1 followed by team2.
+func (h *TeamHandler) GenerateMatches(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	var payload struct {
+		PlayerIDs []string `json:"player_ids"`
+		Count     int      `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	pids, ok := parsePlayerIDs(w, payload.PlayerIDs)
+	if !ok {
+		return
+	}
+
+	matches, err := h.TeamService.GenerateMatches(r.Context(), groupName, pids, payload.Count)
+	if err != nil {
+		writeServiceError(w, r, "Error generating matches", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// parsePlayerIDs converts the player IDs of a payload, writing the error response on failure.
+func parsePlayerIDs(w http.ResponseWriter, ids []string) ([]primitive.ObjectID, bool) {
+	var pids []primitive.ObjectID
+	for _, pid := range ids {
+		objID, err := parseObjectID(pid)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid player ID: "+pid)
+			return nil, false
+		}
+		pids = append(pids, objID)
+	}
+	return pids, true
+}
@@ -1,27 +1,277 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-const webappdir = "ui/dist"
+// DefaultWebAppDir is the directory the web application is built into.
+const DefaultWebAppDir = "ui/dist"
 
-func StaticHandler(w http.ResponseWriter, r *http.Request) {
-	// Clean the URL path
-	cleanPath := path.Clean(strings.TrimPrefix(r.URL.Path, "/app"))
-	filePath := path.Join(webappdir, cleanPath)
+// DefaultContentSecurityPolicy only lets the web application load resources
+// from its own origin. Vue binds inline styles, and avatars are previewed
+// from blob URLs before being uploaded.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; connect-src 'self'; " +
+	"manifest-src 'self'; worker-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
 
-	// Check if the file exists
-	_, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		// If file doesn't exist, serve index.html for client-side routing
-		http.ServeFile(w, r, path.Join(webappdir, "index.html"))
+// StaticOptions configures a StaticHandler.
+type StaticOptions struct {
+	// Live looks the files up on every request instead of indexing them
+	// once, for directories rebuilt during development.
+	Live bool
+
+	// ContentSecurityPolicy of the responses, DefaultContentSecurityPolicy
+	// when empty.
+	ContentSecurityPolicy string
+}
+
+// staticFile describes a file of the web application.
+type staticFile struct {
+	etag    string
+	modTime time.Time
+	gzip    bool // a precompressed name.gz exists
+	brotli  bool // a precompressed name.br exists
+}
+
+// StaticHandler serves the web application, falling back to index.html for
+// the client-side routes.
+type StaticHandler struct {
+	fsys fs.FS
+	csp  string
+	// files indexes the files of fsys, or is nil to look them up on each
+	// request when serving a directory that is rebuilt during development
+	files map[string]*staticFile
+}
+
+// NewStaticHandler serves the web application in fsys.
+func NewStaticHandler(fsys fs.FS, opts StaticOptions) (*StaticHandler, error) {
+	h := &StaticHandler{fsys: fsys, csp: opts.ContentSecurityPolicy}
+	if h.csp == "" {
+		h.csp = DefaultContentSecurityPolicy
+	}
+	if opts.Live {
+		return h, nil
+	}
+
+	h.files = make(map[string]*staticFile)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".br") {
+			return nil
+		}
+		f, err := h.stat(name)
+		if errors.Is(err, fs.ErrPermission) {
+			// Links escaping the directory are left out
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h.files[name] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Serve the actual file if it exists
-	http.ServeFile(w, r, filePath)
+	// Cleaning a rooted path drops every ".." element
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, "/app")), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	f := h.lookup(name)
+	if f == nil {
+		// Client-side routes have no extension; anything else is a missing file
+		if path.Ext(name) != "" || hiddenPath(name) {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+		if f = h.lookup(name); f == nil {
+			http.Error(w, "web application not built", http.StatusNotFound)
+			return
+		}
+	}
+
+	header := w.Header()
+	header.Set("Content-Security-Policy", h.csp)
+	switch {
+	case strings.HasPrefix(name, "assets/"):
+		// Vite fingerprints the names of the assets
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case name == "service-worker.js":
+		// Browsers must see new versions of the worker at once
+		header.Set("Cache-Control", "no-cache, max-age=0")
+		header.Set("Service-Worker-Allowed", "/")
+	default:
+		header.Set("Cache-Control", "no-cache")
+	}
+	switch path.Ext(name) {
+	case ".json":
+		if name == "manifest.json" {
+			header.Set("Content-Type", "application/manifest+json")
+		} else {
+			header.Set("Content-Type", "application/json")
+		}
+	case ".webmanifest":
+		header.Set("Content-Type", "application/manifest+json")
+	default:
+		if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+			header.Set("Content-Type", ctype)
+		}
+	}
+
+	file, encoding := name, ""
+	if f.gzip || f.brotli {
+		header.Add("Vary", "Accept-Encoding")
+		switch accepted := r.Header.Get("Accept-Encoding"); {
+		case f.brotli && acceptsEncoding(accepted, "br"):
+			file, encoding = name+".br", "br"
+		case f.gzip && acceptsEncoding(accepted, "gzip"):
+			file, encoding = name+".gz", "gzip"
+		}
+	}
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	if f.etag != "" {
+		// Each encoding is a different representation
+		etag := f.etag
+		if encoding != "" {
+			etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		}
+		header.Set("ETag", etag)
+	}
+
+	data, err := fs.ReadFile(h.fsys, file)
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, name, f.modTime, bytes.NewReader(data))
+}
+
+// lookup returns the file of the web application with the given name, or nil.
+// Hidden files, such as editor or VCS leftovers, are never served.
+func (h *StaticHandler) lookup(name string) *staticFile {
+	if hiddenPath(name) {
+		return nil
+	}
+	if h.files != nil {
+		return h.files[name]
+	}
+	f, err := h.stat(name)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// stat describes a regular file of the web application. Files without a
+// modification time, as embedded ones, get an ETag from their content.
+func (h *StaticHandler) stat(name string) (*staticFile, error) {
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+
+	f := &staticFile{modTime: info.ModTime()}
+	if f.modTime.IsZero() {
+		file, err := h.fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		sum := sha256.New()
+		if _, err := io.Copy(sum, file); err != nil {
+			return nil, err
+		}
+		f.etag = `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+	}
+	_, err = fs.Stat(h.fsys, name+".gz")
+	f.gzip = err == nil
+	_, err = fs.Stat(h.fsys, name+".br")
+	f.brotli = err == nil
+	return f, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// hiddenPath reports whether any element of a slash-separated path starts
+// with a dot.
+func hiddenPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// rootDir is a directory tree that, unlike os.DirFS, refuses to open files
+// reached through symbolic links pointing outside of it.
+type rootDir string
+
+// DirFS returns the file system of the web application in dir.
+func DirFS(dir string) (fs.FS, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	return rootDir(root), nil
+}
+
+func (d rootDir) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(string(d), filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if rel, err := filepath.Rel(string(d), resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return os.Open(resolved)
 }
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookHandler manages the webhooks of a group. All endpoints require the
+// group password.
+type WebhookHandler struct {
+	GroupService   *services.GroupService
+	WebhookService *services.WebhookService
+}
+
+// POST /api/group/{name}/webhooks?password=SECRET
+// Payload: { "url": "https://example.org/hook", "events": ["match.created", ...] }
+// An empty event list subscribes to every event. The signing secret is only
+// returned in this response.
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	var payload struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	wh, err := h.WebhookService.CreateWebhook(r.Context(), groupName, payload.URL, payload.Events)
+	if err != nil {
+		writeServiceError(w, r, "Error creating webhook", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, wh)
+}
+
+// GET /api/group/{name}/webhooks?password=SECRET
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	webhooks, err := h.WebhookService.ListWebhooks(r.Context(), groupName)
+	if err != nil {
+		writeServiceError(w, r, "Error listing webhooks", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+// DELETE /api/group/{name}/webhooks/{webhook_id}?password=SECRET
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	webhookID, err := parseObjectID(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := h.WebhookService.DeleteWebhook(r.Context(), groupName, webhookID); err != nil {
+		writeServiceError(w, r, "Error deleting webhook", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// GET /api/group/{name}/webhooks/deliveries?password=SECRET&status=failed&webhook_id=ID
+// Returns the latest deliveries, newest first.
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	status := getQueryParam(r, "status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		writeError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	var webhookID *primitive.ObjectID
+	if v := getQueryParam(r, "webhook_id"); v != "" {
+		id, err := parseObjectID(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+			return
+		}
+		webhookID = &id
+	}
+
+	deliveries, err := h.WebhookService.ListDeliveries(r.Context(), groupName, webhookID, status)
+	if err != nil {
+		writeServiceError(w, r, "Error listing deliveries", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// POST /api/group/{name}/webhooks/deliveries/{delivery_id}/retry?password=SECRET
+func (h *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	groupName := groupParam(r)
+
+	if !checkGroupPassword(w, r, h.GroupService, groupName) {
+		return
+	}
+
+	deliveryID, err := parseObjectID(chi.URLParam(r, "delivery_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	if err := h.WebhookService.RetryDelivery(r.Context(), groupName, deliveryID); err != nil {
+		writeServiceError(w, r, "Error retrying delivery", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "queued"})
+}
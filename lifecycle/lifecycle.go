@@ -0,0 +1,129 @@
+// Package lifecycle starts the components of the application in order and
+// stops them in reverse order, so that each component stops before the ones
+// it depends on.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Hook starts and stops a component. Either function may be nil.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+
+	// Timeout bounds Stop, the default timeout of the manager when zero.
+	Timeout time.Duration
+}
+
+// Manager runs the hooks of the components in the order they were added.
+type Manager struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started int // hooks started, to stop
+}
+
+// New returns a manager giving timeout to the hooks without their own.
+func New(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout}
+}
+
+// Append adds a component, started after and stopped before the previous
+// ones.
+func (m *Manager) Append(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, h)
+}
+
+// Go adds a background worker, running until its context is cancelled on
+// stop. Stopping waits for run to return.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	m.Append(Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+}
+
+// Start starts the components not started yet, in order. When one fails,
+// the ones started so far are stopped and its error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.started < len(m.hooks) {
+		h := m.hooks[m.started]
+		if h.Start != nil {
+			if err := h.Start(ctx); err != nil {
+				err = fmt.Errorf("%s: %w", h.Name, err)
+				return errors.Join(err, m.stopLocked())
+			}
+		}
+		m.started++
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order, each within its
+// timeout, and returns the errors of the ones that failed to stop, such as
+// workers still draining, naming each component. It stops every component
+// even when some fail.
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopLocked()
+}
+
+func (m *Manager) stopLocked() error {
+	var errs []error
+	for ; m.started > 0; m.started-- {
+		h := m.hooks[m.started-1]
+		if h.Stop == nil {
+			continue
+		}
+		timeout := h.Timeout
+		if timeout <= 0 {
+			timeout = m.timeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := h.Stop(ctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("did not stop within %s", timeout)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.Name, err))
+			continue
+		}
+		slog.Debug("Stopped", "component", h.Name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,302 @@
+// Package live keeps the point-by-point scoring sessions of pending matches
+// and broadcasts their score to the connected scorers and spectators.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/p4u/padelfriends/events"
+	"github.com/p4u/padelfriends/scoring"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Message types exchanged with the clients.
+const (
+	TypePoint     = "point"     // client: point won by Team
+	TypeUndo      = "undo"      // client: remove the last point
+	TypeFinalize  = "finalize"  // client: record the result of a finished match
+	TypeState     = "state"     // server: current score
+	TypeFinalized = "finalized" // server: result recorded, session closed
+	TypeClosed    = "closed"    // server: match cancelled or scored elsewhere
+	TypeShutdown  = "shutdown"  // server: stopping, the live score is lost
+	TypeError     = "error"     // server: the last command was rejected
+)
+
+// Command is a message sent by a scorer.
+type Command struct {
+	Type string `json:"type"`
+	Team int    `json:"team,omitempty"`
+}
+
+// Message is a message sent to the clients.
+type Message struct {
+	Type    string         `json:"type"`
+	MatchID string         `json:"match_id"`
+	State   *scoring.State `json:"state,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// Finalizer records the result of a scored match.
+type Finalizer interface {
+	SubmitScoredResults(ctx context.Context, groupName string, matchID primitive.ObjectID, format scoring.Format, sets [][2]int) error
+}
+
+// Hub holds the scoring sessions, one per pending match.
+type Hub struct {
+	mu        sync.Mutex
+	sessions  map[primitive.ObjectID]*Session
+	finalizer Finalizer
+}
+
+// NewHub creates a Hub recording finished matches through finalizer.
+func NewHub(finalizer Finalizer) *Hub {
+	return &Hub{
+		sessions:  make(map[primitive.ObjectID]*Session),
+		finalizer: finalizer,
+	}
+}
+
+// Run closes the sessions of matches that are cancelled or get a result
+// submitted by other means, until ctx is done.
+func (h *Hub) Run(ctx context.Context, bus *events.Bus) {
+	sub := bus.Subscribe("")
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				sub = bus.Subscribe("")
+				continue
+			}
+			if ev.Type != events.MatchCancelled && ev.Type != events.ResultSubmitted {
+				continue
+			}
+			data, _ := ev.Data.(map[string]interface{})
+			if matchID, ok := data["match_id"].(primitive.ObjectID); ok {
+				h.close(matchID, TypeClosed)
+			}
+		}
+	}
+}
+
+// Join returns the session of a match of a group, starting it with the given
+// format if needed, and registers a client receiving its messages.
+func (h *Hub) Join(groupName string, matchID primitive.ObjectID, format scoring.Format) (*Session, *Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.sessions[matchID]
+	if !ok {
+		m, err := scoring.NewMatch(format)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = &Session{
+			hub:       h,
+			groupName: groupName,
+			matchID:   matchID,
+			match:     m,
+			clients:   make(map[*Client]struct{}),
+		}
+		h.sessions[matchID] = s
+	}
+
+	c := &Client{session: s, send: make(chan []byte, 16)}
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	return s, c, nil
+}
+
+// close ends a session and notifies its clients.
+func (h *Hub) close(matchID primitive.ObjectID, reason string) {
+	h.mu.Lock()
+	s, ok := h.sessions[matchID]
+	delete(h.sessions, matchID)
+	h.mu.Unlock()
+	if ok {
+		s.closeClients(reason)
+	}
+}
+
+// Shutdown ends every session, telling its clients that the server stops.
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	sessions := h.sessions
+	h.sessions = make(map[primitive.ObjectID]*Session)
+	h.mu.Unlock()
+	for _, s := range sessions {
+		s.closeClients(TypeShutdown)
+	}
+}
+
+// Session is the live score of one match.
+type Session struct {
+	hub       *Hub
+	groupName string
+	matchID   primitive.ObjectID
+
+	mu         sync.Mutex
+	match      *scoring.Match
+	clients    map[*Client]struct{}
+	finalizing bool
+	closed     bool
+}
+
+// Client is a connection following a session.
+type Client struct {
+	session *Session
+	send    chan []byte
+}
+
+// Send returns the channel of the encoded messages for the client. It is
+// closed when the session ends or the client is too slow.
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+// Leave unregisters the client. Sessions without points and clients are discarded.
+func (c *Client) Leave() {
+	s := c.session
+	s.mu.Lock()
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.send)
+	}
+	empty := len(s.clients) == 0 && s.match.State().Played == 0
+	s.mu.Unlock()
+
+	if empty {
+		s.hub.mu.Lock()
+		if s.hub.sessions[s.matchID] == s {
+			delete(s.hub.sessions, s.matchID)
+		}
+		s.hub.mu.Unlock()
+	}
+}
+
+// Snapshot returns the current state message.
+func (s *Session) Snapshot() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stateMessage(TypeState)
+}
+
+// Apply executes a scorer command and broadcasts the new state. Errors are
+// returned to be reported to the scorer only.
+func (s *Session) Apply(ctx context.Context, cmd Command) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("session closed")
+	}
+	if s.finalizing && cmd.Type != TypeFinalize {
+		s.mu.Unlock()
+		return errors.New("match is being finalized")
+	}
+
+	var err error
+	switch cmd.Type {
+	case TypePoint:
+		err = s.match.PointWon(cmd.Team)
+	case TypeUndo:
+		err = s.match.Undo()
+	case TypeFinalize:
+		s.mu.Unlock()
+		return s.finalize(ctx)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd.Type)
+	}
+	if err == nil {
+		s.broadcastLocked(s.stateMessage(TypeState))
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// finalize records the result of a finished match and ends the session.
+func (s *Session) finalize(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.match.State().Finished {
+		s.mu.Unlock()
+		return errors.New("match is not finished yet")
+	}
+	if s.finalizing {
+		s.mu.Unlock()
+		return errors.New("match is already being finalized")
+	}
+	s.finalizing = true
+	state := s.match.State()
+	s.mu.Unlock()
+
+	// Detach the session first, so that the result submitted event does not
+	// close it as if the match had been scored elsewhere
+	h := s.hub
+	h.mu.Lock()
+	if h.sessions[s.matchID] == s {
+		delete(h.sessions, s.matchID)
+	}
+	h.mu.Unlock()
+
+	if err := h.finalizer.SubmitScoredResults(ctx, s.groupName, s.matchID, state.Format, state.Sets); err != nil {
+		h.mu.Lock()
+		if _, ok := h.sessions[s.matchID]; !ok {
+			h.sessions[s.matchID] = s
+		}
+		h.mu.Unlock()
+
+		s.mu.Lock()
+		s.finalizing = false
+		s.mu.Unlock()
+		return err
+	}
+
+	s.closeClients(TypeFinalized)
+	return nil
+}
+
+// closeClients sends a last message and disconnects every client.
+func (s *Session) closeClients(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.broadcastLocked(s.stateMessage(reason))
+	for c := range s.clients {
+		delete(s.clients, c)
+		close(c.send)
+	}
+}
+
+// ErrorMessage encodes an error message for a single client.
+func (s *Session) ErrorMessage(err error) []byte {
+	msg, _ := json.Marshal(Message{Type: TypeError, MatchID: s.matchID.Hex(), Error: err.Error()})
+	return msg
+}
+
+func (s *Session) stateMessage(msgType string) []byte {
+	state := s.match.State()
+	msg, _ := json.Marshal(Message{Type: msgType, MatchID: s.matchID.Hex(), State: &state})
+	return msg
+}
+
+func (s *Session) broadcastLocked(msg []byte) {
+	for c := range s.clients {
+		select {
+		case c.send <- msg:
+		default:
+			// Too slow: disconnect, it gets the full state when reconnecting
+			delete(s.clients, c)
+			close(c.send)
+		}
+	}
+}
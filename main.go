@@ -1,75 +1,86 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
 	"github.com/p4u/padelfriends/config"
-	"github.com/p4u/padelfriends/db"
-	"github.com/p4u/padelfriends/handlers"
-	"github.com/p4u/padelfriends/router"
-	"github.com/p4u/padelfriends/services"
+	"github.com/p4u/padelfriends/logging"
 )
 
 func main() {
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		printConfig(args[2:])
+		return
 	}
 
-	// Connect to MongoDB
-	mdb, err := db.Connect(cfg)
+	// Load configuration, the flags preceding the command
+	cfg, args := loadConfig(args)
+
+	// Application log on stderr
+	logger, err := logging.NewLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		fatal("Failed to configure logging", err)
 	}
+	logging.SetDefault(logger)
 
-	// Initialize services
-	groupService := services.NewGroupService(mdb.Database)
-	playerService := services.NewPlayerService(mdb.Database)
-	matchService := services.NewMatchService(mdb.Database)
-	statsService := services.NewStatsService(mdb.Database)
-
-	// Initialize handlers
-	groupHandler := &handlers.GroupHandler{GroupService: groupService}
-	playerHandler := &handlers.PlayerHandler{GroupService: groupService, PlayerService: playerService}
-	matchHandler := &handlers.MatchHandler{GroupService: groupService, MatchService: matchService}
-	statsHandler := &handlers.StatsHandler{GroupService: groupService, StatsService: statsService}
-
-	// Create router
-	r := router.New(groupHandler, playerHandler, matchHandler, statsHandler)
-
-	// Start server
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: r,
+	if len(args) == 0 {
+		serve(cfg)
+		return
 	}
+	os.Exit(runCommand(cfg, args))
+}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		log.Printf("Starting server on port %d...", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe error: %v", err)
-		}
-	}()
-
-	<-stop
-	log.Println("Shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// loadConfig loads the configuration from the command-line arguments and
+// returns it with the command, exiting on invalid settings.
+func loadConfig(args []string) (*config.Config, []string) {
+	cfg, args, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stderr)
+		os.Exit(0)
+	}
+	if len(args) > 0 && args[0] == "help" {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	return cfg, args
+}
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server Shutdown Failed:%+v", err)
+// printConfig prints the configuration resulting from the arguments, with
+// the secrets masked, followed by its problems if any.
+func printConfig(args []string) {
+	cfg, args, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if cfg == nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(args, " "))
+		os.Exit(2)
+	}
+	if perr := config.Print(os.Stdout, cfg); perr != nil {
+		fatal("Failed to print the configuration", perr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+}
 
-	log.Println("Server exited properly")
+// fatal logs an error preventing the server from running and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
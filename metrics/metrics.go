@@ -0,0 +1,164 @@
+// Package metrics exposes the Prometheus metrics of the server: the HTTP
+// requests per route, the MongoDB operations per service method, business
+// gauges and the Go runtime.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/p4u/padelfriends/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// namespace prefixes the names of the metrics.
+const namespace = "padelfriends"
+
+// Metrics holds the collectors of the server in their own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	dbDuration   *prometheus.HistogramVec
+	dbErrors     *prometheus.CounterVec
+
+	matchesCreated   prometheus.Counter
+	resultsSubmitted prometheus.Counter
+	playersAdded     prometheus.Counter
+}
+
+// New creates the metrics of the server.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "mongodb_operation_duration_seconds",
+			Help:      "Latency of the MongoDB commands by service method and command.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"method", "command"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mongodb_operation_errors_total",
+			Help:      "Failed MongoDB commands by service method and command.",
+		}, []string{"method", "command"}),
+		matchesCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "matches_created_total",
+			Help:      "Matches created since the server started.",
+		}),
+		resultsSubmitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "results_submitted_total",
+			Help:      "Match results submitted since the server started.",
+		}),
+		playersAdded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "players_added_total",
+			Help:      "Players added since the server started.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.dbDuration,
+		m.dbErrors,
+		m.matchesCreated,
+		m.resultsSubmitted,
+		m.playersAdded,
+	)
+	return m
+}
+
+// CountStore adds the gauges of the groups, players and matches of the
+// database, counted on each scrape.
+func (m *Metrics) CountStore(database *mongo.Database) {
+	m.registry.MustRegister(newStoreCollector(database))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware counts the requests and their latency by route pattern. It must
+// be installed on the chi router, so that the route pattern is known once
+// the handler returns.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// Unmatched paths share a label, so that scanners cannot add series
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		method := r.Method
+		if !knownMethods[method] {
+			method = "other"
+		}
+		m.httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// knownMethods are the request methods labelled as such.
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// Run counts the business events published on the bus until the context is
+// cancelled.
+func (m *Metrics) Run(ctx context.Context, bus *events.Bus) {
+	sub := bus.Subscribe("")
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				sub = bus.Subscribe("")
+				continue
+			}
+			switch ev.Type {
+			case events.MatchCreated:
+				m.matchesCreated.Inc()
+			case events.ResultSubmitted:
+				m.resultsSubmitted.Inc()
+			case events.PlayerAdded:
+				m.playersAdded.Inc()
+			}
+		}
+	}
+}
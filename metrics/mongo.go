@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// servicesPackage is the package whose methods label the MongoDB commands.
+const servicesPackage = "github.com/p4u/padelfriends/services."
+
+// CommandMonitor returns a MongoDB command monitor recording the latency of
+// the commands by the service method running them.
+func (m *Metrics) CommandMonitor() *event.CommandMonitor {
+	var started sync.Map // request ID -> service method
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			// The driver runs the monitor on the goroutine of the operation
+			started.Store(evt.RequestID, serviceMethod())
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			method, _ := started.LoadAndDelete(evt.RequestID)
+			m.observeCommand(method, evt.CommandName, evt.Duration.Seconds(), false)
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			method, _ := started.LoadAndDelete(evt.RequestID)
+			m.observeCommand(method, evt.CommandName, evt.Duration.Seconds(), true)
+		},
+	}
+}
+
+func (m *Metrics) observeCommand(method interface{}, command string, seconds float64, failed bool) {
+	name, _ := method.(string)
+	if name == "" {
+		name = "other"
+	}
+	m.dbDuration.WithLabelValues(name, command).Observe(seconds)
+	if failed {
+		m.dbErrors.WithLabelValues(name, command).Inc()
+	}
+}
+
+// serviceMethod returns the outermost method of the services package on the
+// call stack, such as "MatchService.ListMatches", or an empty string.
+func serviceMethod() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	method := ""
+	for {
+		frame, more := frames.Next()
+		if name, ok := strings.CutPrefix(frame.Function, servicesPackage); ok {
+			method = name
+		}
+		if !more {
+			break
+		}
+	}
+	if method == "" {
+		return ""
+	}
+
+	// "(*MatchService).CreateMatches.func1" -> "MatchService.CreateMatches"
+	method = strings.NewReplacer("(*", "", ")", "").Replace(method)
+	if parts := strings.Split(method, "."); len(parts) > 2 {
+		method = parts[0] + "." + parts[1]
+	}
+	return method
+}
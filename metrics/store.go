@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// storeTimeout bounds the queries of a scrape.
+const storeTimeout = 5 * time.Second
+
+// storeCollector counts the groups, players and matches in the database when
+// scraped, so that the gauges survive restarts and multiple instances.
+type storeCollector struct {
+	db      *mongo.Database
+	groups  *prometheus.Desc
+	players *prometheus.Desc
+	matches *prometheus.Desc
+}
+
+func newStoreCollector(db *mongo.Database) *storeCollector {
+	return &storeCollector{
+		db: db,
+		groups: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "groups"),
+			"Groups in the database.", nil, nil),
+		players: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "players"),
+			"Players in the database, by activity.", []string{"state"}, nil),
+		matches: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "matches"),
+			"Matches in the database, by status.", []string{"status"}, nil),
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.groups
+	ch <- c.players
+	ch <- c.matches
+}
+
+// Collect skips the gauges it fails to count, so that a database outage
+// does not hide the other metrics.
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if n, err := c.db.Collection("groups").EstimatedDocumentCount(ctx); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.groups, prometheus.GaugeValue, float64(n))
+	} else {
+		slog.ErrorContext(ctx, "metrics: cannot count groups", "error", err)
+	}
+
+	players, err := c.countBy(ctx, "players", "$inactive")
+	if err != nil {
+		slog.ErrorContext(ctx, "metrics: cannot count players", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.players, prometheus.GaugeValue, players[true], "inactive")
+		ch <- prometheus.MustNewConstMetric(c.players, prometheus.GaugeValue, players[false]+players[nil], "active")
+	}
+
+	matches, err := c.countBy(ctx, "matches", "$status")
+	if err != nil {
+		slog.ErrorContext(ctx, "metrics: cannot count matches", "error", err)
+	} else {
+		for _, status := range []string{"pending", "completed", "cancelled"} {
+			ch <- prometheus.MustNewConstMetric(c.matches, prometheus.GaugeValue, matches[status], status)
+		}
+	}
+}
+
+// countBy counts the documents of a collection grouped by a field.
+func (c *storeCollector) countBy(ctx context.Context, collection, field string) (map[interface{}]float64, error) {
+	cur, err := c.db.Collection(collection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": field, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rows []struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[interface{}]float64, len(rows))
+	for _, row := range rows {
+		counts[row.ID] += float64(row.Count)
+	}
+	return counts, nil
+}
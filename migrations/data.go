@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillSlugs assigns a slug to the groups created before slugs existed,
+// so that they are reachable on /api/v2.
+func backfillSlugs(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+	if dryRun {
+		n, err := db.Collection("groups").CountDocuments(ctx, bson.M{"slug": bson.M{"$in": bson.A{nil, ""}}})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("would assign slugs to %d groups", n), nil
+	}
+	n, err := services.NewGroupService(db).BackfillSlugs(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("assigned slugs to %d groups", n), nil
+}
@@ -0,0 +1,232 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// index is an index the services rely on, either to enforce uniqueness
+// under concurrent requests or to serve their queries.
+type index struct {
+	collection string
+	name       string
+	keys       bson.D
+	unique     bool
+	partial    bson.M // indexes only the matching documents when set
+
+	// expires removes the documents expireAfter past the time of their key.
+	expires     bool
+	expireAfter time.Duration
+}
+
+var indexesV2 = []index{
+	{collection: "groups", name: "name_unique", keys: bson.D{{Key: "name", Value: 1}}, unique: true},
+	// Groups created before slugs existed have none until migration 1
+	{collection: "groups", name: "slug_unique", keys: bson.D{{Key: "slug", Value: 1}}, unique: true,
+		partial: bson.M{"slug": bson.M{"$gt": ""}}},
+
+	{collection: "players", name: "group_name_name_unique",
+		keys: bson.D{{Key: "group_name", Value: 1}, {Key: "name", Value: 1}}, unique: true},
+	{collection: "players", name: "identity_id", keys: bson.D{{Key: "identity_id", Value: 1}},
+		partial: bson.M{"identity_id": bson.M{"$exists": true}}},
+
+	// Serves the match listing, in its order
+	{collection: "matches", name: "group_name_timestamp",
+		keys: bson.D{{Key: "group_name", Value: 1}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}},
+	{collection: "matchdetails", name: "match_id_unique", keys: bson.D{{Key: "match_id", Value: 1}}, unique: true},
+
+	{collection: "webhooks", name: "group_name", keys: bson.D{{Key: "group_name", Value: 1}}},
+	{collection: "webhook_deliveries", name: "status_next_attempt",
+		keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}}},
+
+	{collection: "bot_links", name: "transport_chat_id_unique",
+		keys: bson.D{{Key: "transport", Value: 1}, {Key: "chat_id", Value: 1}}, unique: true},
+	{collection: "bot_link_tokens", name: "token_hash_unique", keys: bson.D{{Key: "token_hash", Value: 1}}, unique: true},
+	{collection: "bot_link_tokens", name: "expires_at_ttl", keys: bson.D{{Key: "expires_at", Value: 1}}, expires: true},
+
+	{collection: "idempotency_keys", name: "created_at_ttl", keys: bson.D{{Key: "created_at", Value: 1}},
+		expires: true, expireAfter: services.IdempotencyKeyTTL},
+}
+
+// createIndexes returns a migration creating the missing indexes. Unique
+// indexes are only created when the existing documents have no duplicates,
+// which are reported instead.
+func createIndexes(indexes []index) func(context.Context, *mongo.Database, bool) (string, error) {
+	return func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error) {
+		var created []string
+		for _, ix := range indexes {
+			coll := db.Collection(ix.collection)
+			exists, err := hasIndex(ctx, coll, ix)
+			if err != nil {
+				return "", err
+			}
+			if exists {
+				continue
+			}
+			if ix.unique {
+				if err := checkDuplicates(ctx, coll, ix); err != nil {
+					return "", err
+				}
+			}
+			created = append(created, ix.collection+"."+ix.name)
+			if dryRun {
+				continue
+			}
+			if _, err := coll.Indexes().CreateOne(ctx, ix.model()); err != nil {
+				return "", fmt.Errorf("%s.%s: %w", ix.collection, ix.name, err)
+			}
+		}
+
+		if len(created) == 0 {
+			return "indexes already exist", nil
+		}
+		verb := "created"
+		if dryRun {
+			verb = "would create"
+		}
+		return verb + " " + strings.Join(created, ", "), nil
+	}
+}
+
+func (ix index) model() mongo.IndexModel {
+	opts := options.Index().SetName(ix.name)
+	if ix.unique {
+		opts.SetUnique(true)
+	}
+	if ix.partial != nil {
+		opts.SetPartialFilterExpression(ix.partial)
+	}
+	if ix.expires {
+		opts.SetExpireAfterSeconds(int32(ix.expireAfter / time.Second))
+	}
+	return mongo.IndexModel{Keys: ix.keys, Options: opts}
+}
+
+// hasIndex reports whether the collection has the index, whatever its
+// name, so that indexes created by hand are not duplicated. An index on the
+// same keys but with other options is an error rather than a match, since a
+// non-unique one would leave the uniqueness unenforced.
+func hasIndex(ctx context.Context, coll *mongo.Collection, ix index) (bool, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	var existing []struct {
+		Name    string `bson:"name"`
+		Keys    bson.D `bson:"key"`
+		Unique  bool   `bson:"unique"`
+		Partial bson.M `bson:"partialFilterExpression"`
+	}
+	if err := cur.All(ctx, &existing); err != nil {
+		return false, err
+	}
+	want, err := normalize(ix.partial)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range existing {
+		if !sameKeys(e.Keys, ix.keys) {
+			continue
+		}
+		have, err := normalize(e.Partial)
+		if err != nil {
+			return false, err
+		}
+		if e.Unique != ix.unique || !reflect.DeepEqual(have, want) {
+			return false, fmt.Errorf("cannot create the index %s.%s, the index %s on the same keys has other options "+
+				"(unique %t, partial filter %v), drop it first", ix.collection, ix.name, e.Name, e.Unique, e.Partial)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// normalize round-trips a filter through BSON so that filters built here
+// compare equal to the ones the server returns.
+func normalize(filter bson.M) (bson.M, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	var out bson.M
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sameKeys compares index keys, whose directions the server may return as
+// any numeric type.
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || direction(a[i].Value) != direction(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func direction(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return v
+}
+
+// checkDuplicates returns an error listing a few of the values that appear
+// more than once for the keys of a unique index.
+func checkDuplicates(ctx context.Context, coll *mongo.Collection, ix index) error {
+	group := bson.M{}
+	for _, k := range ix.keys {
+		group[k.Key] = "$" + k.Key
+	}
+	pipeline := mongo.Pipeline{}
+	if ix.partial != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: ix.partial}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{"_id": group, "count": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		bson.D{{Key: "$limit", Value: 5}},
+	)
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var dups []struct {
+		Values bson.Raw `bson:"_id"`
+		Count  int      `bson:"count"`
+	}
+	if err := cur.All(ctx, &dups); err != nil {
+		return err
+	}
+	if len(dups) == 0 {
+		return nil
+	}
+
+	examples := make([]string, len(dups))
+	for i, d := range dups {
+		examples[i] = fmt.Sprintf("%s (%d times)", d.Values, d.Count)
+	}
+	return fmt.Errorf("cannot create the unique index %s.%s, remove the duplicates first: %s",
+		ix.collection, ix.name, strings.Join(examples, ", "))
+}
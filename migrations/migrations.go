@@ -0,0 +1,148 @@
+// Package migrations upgrades the database of older versions: it creates the
+// indexes the services rely on and rewrites the documents whose schema
+// changed. The applied versions are recorded in the schema_migrations
+// collection.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection records the applied migrations.
+const Collection = "schema_migrations"
+
+// Migration is a numbered change to the database. Migrations must be
+// idempotent, as instances starting together may both apply the same one.
+type Migration struct {
+	Version     int
+	Description string
+
+	// Up applies the migration and reports what it changed, or only what it
+	// would change with dryRun.
+	Up func(ctx context.Context, db *mongo.Database, dryRun bool) (string, error)
+}
+
+// All lists the migrations by increasing version. New migrations are
+// appended; applied ones are never renumbered nor removed.
+var All = []Migration{
+	{Version: 1, Description: "assign slugs to the groups", Up: backfillSlugs},
+	{Version: 2, Description: "create the unique and listing indexes", Up: createIndexes(indexesV2)},
+}
+
+// Status is the state of a migration.
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Result reports a migration that was applied, or that would be.
+type Result struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Report      string `json:"report"`
+}
+
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+}
+
+// New returns a migrator applying All to db.
+func New(db *mongo.Database) *Migrator {
+	return &Migrator{db: db, migrations: All}
+}
+
+// Status returns every migration with the time it was applied, if it was.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = Status{Version: mig.Version, Description: mig.Description}
+		if r, ok := applied[mig.Version]; ok {
+			appliedAt := r.AppliedAt
+			status[i].AppliedAt = &appliedAt
+		}
+	}
+	return status, nil
+}
+
+// Pending returns the migrations that were not applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations in order and records them, stopping at
+// the first failure. With dryRun, it reports what they would change without
+// changing nor recording anything.
+func (m *Migrator) Up(ctx context.Context, dryRun bool) ([]Result, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := []Result{}
+	for _, mig := range pending {
+		report, err := mig.Up(ctx, m.db, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+		}
+		results = append(results, Result{Version: mig.Version, Description: mig.Description, Report: report})
+		if dryRun {
+			continue
+		}
+
+		// Another instance may have applied it meanwhile
+		_, err = m.db.Collection(Collection).UpdateOne(ctx,
+			bson.M{"_id": mig.Version},
+			bson.M{"$setOnInsert": bson.M{"description": mig.Description, "applied_at": time.Now()}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return results, fmt.Errorf("migration %d: cannot record: %w", mig.Version, err)
+		}
+	}
+	return results, nil
+}
+
+// applied returns the records of the applied migrations by version.
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cur, err := m.db.Collection(Collection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
@@ -9,9 +9,30 @@ import (
 
 // Group represents a padel group context.
 type Group struct {
-	Name         string    `bson:"name" json:"name"`
-	PasswordHash string    `bson:"password_hash" json:"-"`
-	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	Name         string        `bson:"name" json:"name"`
+	Slug         string        `bson:"slug,omitempty" json:"slug"` // URL-safe identifier used by the v2 API
+	PasswordHash string        `bson:"password_hash" json:"-"`
+	CreatedAt    time.Time     `bson:"created_at" json:"created_at"`
+	Settings     GroupSettings `bson:"settings" json:"settings"`
+}
+
+// Guest statistics modes for GroupSettings.GuestStats.
+const (
+	GuestStatsExclude = "exclude" // matches with guests are left out of statistics
+	GuestStatsMark    = "mark"    // matches with guests are counted and flagged
+)
+
+// GroupSettings holds the per-group preferences.
+type GroupSettings struct {
+	GuestStats string `bson:"guest_stats,omitempty" json:"guest_stats"`
+}
+
+// GuestStatsMode returns the guest statistics mode, defaulting to GuestStatsExclude.
+func (s GroupSettings) GuestStatsMode() string {
+	if s.GuestStats == GuestStatsMark {
+		return GuestStatsMark
+	}
+	return GuestStatsExclude
 }
 
 // Player represents a player within a group.
@@ -19,6 +40,57 @@ type Player struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	GroupName string             `bson:"group_name" json:"group_name"`
 	Name      string             `bson:"name" json:"name"`
+	Inactive  bool               `bson:"inactive,omitempty" json:"inactive"`
+	Guest     bool               `bson:"guest,omitempty" json:"guest"`
+	Avatar    string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+
+	PlayerAttributes `bson:",inline"`
+
+	// IdentityID links the player to a global identity. It is never exposed
+	// through the group endpoints so that links stay private to the identity owner.
+	IdentityID *primitive.ObjectID `bson:"identity_id,omitempty" json:"-"`
+}
+
+// Preferred court sides for PlayerAttributes.PreferredSide.
+const (
+	SideDrive = "drive"
+	SideReves = "reves"
+	SideBoth  = "both"
+)
+
+// Handedness values for PlayerAttributes.Handedness.
+const (
+	HandRight = "right"
+	HandLeft  = "left"
+)
+
+// Self-declared level bounds; a zero level means unknown.
+const (
+	MinPlayerLevel = 1.0
+	MaxPlayerLevel = 7.0
+)
+
+// PlayerAttributes holds the optional playing preferences of a player.
+type PlayerAttributes struct {
+	PreferredSide string         `bson:"preferred_side,omitempty" json:"preferred_side,omitempty"`
+	Handedness    string         `bson:"handedness,omitempty" json:"handedness,omitempty"`
+	Level         float64        `bson:"level,omitempty" json:"level,omitempty"`
+	Contact       *PlayerContact `bson:"contact,omitempty" json:"contact,omitempty"`
+}
+
+// PlayerContact is only visible to authenticated group members.
+type PlayerContact struct {
+	Email string `bson:"email,omitempty" json:"email,omitempty"`
+	Phone string `bson:"phone,omitempty" json:"phone,omitempty"`
+}
+
+// Identity is an optional global player identity that players of different
+// groups can be linked to.
+type Identity struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	PasswordHash string             `bson:"password_hash" json:"-"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 }
 
 // Match represents a single match played in a group.
@@ -27,6 +99,18 @@ type Match struct {
 	GroupName string             `bson:"group_name" json:"group_name"`
 	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 	Status    string             `bson:"status" json:"status"` // "pending", "completed", "cancelled"
+	HasGuests bool               `bson:"has_guests,omitempty" json:"has_guests"`
+
+	// When the result was submitted; unset for the matches completed
+	// before it was recorded
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+
+	// Incremented by every update, for optimistic concurrency control
+	Version int `bson:"version" json:"version"`
+
+	// A completed match whose result a member has contested
+	Disputed      bool   `bson:"disputed,omitempty" json:"disputed,omitempty"`
+	DisputeReason string `bson:"dispute_reason,omitempty" json:"dispute_reason,omitempty"`
 }
 
 // MatchDetail stores the details of a match (teams, scores).
@@ -36,6 +120,10 @@ type MatchDetail struct {
 	Team2      []primitive.ObjectID `bson:"team2" json:"team2"`
 	ScoreTeam1 int                  `bson:"score_team1" json:"score_team1"`
 	ScoreTeam2 int                  `bson:"score_team2" json:"score_team2"`
+
+	// Games of every set of the matches scored set by set, which are won by
+	// the team winning the most sets
+	Sets [][2]int `bson:"sets,omitempty" json:"sets,omitempty"`
 }
 
 // MatchResponse combines Match and MatchDetail with player names for API responses
@@ -47,13 +135,70 @@ type MatchResponse struct {
 	Team2      []PlayerInfo       `json:"team2"`
 	ScoreTeam1 int                `json:"score_team1"`
 	ScoreTeam2 int                `json:"score_team2"`
+	Sets       [][2]int           `json:"sets,omitempty"`
 	Status     string             `json:"status"`
+	HasGuests  bool               `json:"has_guests"`
+	Disputed   bool               `json:"disputed,omitempty"`
+	Version    int                `json:"version"`
 }
 
 // PlayerInfo contains the essential player information for responses
 type PlayerInfo struct {
-	ID   primitive.ObjectID `json:"id"`
-	Name string             `json:"name"`
+	ID    primitive.ObjectID `json:"id"`
+	Name  string             `json:"name"`
+	Guest bool               `json:"guest,omitempty"`
+}
+
+// Webhook is a subscription of an external URL to the events of a group.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GroupName string             `bson:"group_name" json:"group_name"`
+	URL       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"` // only returned on creation
+	Events    []string           `bson:"events" json:"events"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a queued or attempted call of a webhook.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	WebhookID      primitive.ObjectID `bson:"webhook_id" json:"webhook_id"`
+	GroupName      string             `bson:"group_name" json:"group_name"`
+	EventID        string             `bson:"event_id" json:"event_id"`
+	EventType      string             `bson:"event_type" json:"event_type"`
+	Payload        string             `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"`
+	Attempts       int                `bson:"attempts" json:"attempts"`
+	NextAttempt    time.Time          `bson:"next_attempt" json:"next_attempt"`
+	LastStatusCode int                `bson:"last_status_code,omitempty" json:"last_status_code,omitempty"`
+	LastError      string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// BotLink connects a chat of a bot transport to a group.
+type BotLink struct {
+	Transport string    `bson:"transport" json:"transport"`
+	ChatID    string    `bson:"chat_id" json:"chat_id"`
+	GroupName string    `bson:"group_name" json:"group_name"`
+	LinkedAt  time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// IdempotencyKey records the matches created by a batch request carrying an
+// Idempotency-Key header, so that retries of the request return them again.
+type IdempotencyKey struct {
+	ID          string               `bson:"_id" json:"-"` // hash of the group name and key
+	GroupName   string               `bson:"group_name" json:"group_name"`
+	RequestHash string               `bson:"request_hash" json:"-"`
+	MatchIDs    []primitive.ObjectID `bson:"match_ids" json:"match_ids"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
 }
 
 // HashPassword hashes the given password using bcrypt.
@@ -0,0 +1,290 @@
+// Package notify sends email notifications about group events: new matches,
+// submitted or disputed results and a weekly digest with the standings.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/p4u/padelfriends/events"
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notifier turns the bus events into emails to the players involved.
+type Notifier struct {
+	Mailer    Mailer
+	Templates *Templates
+
+	Groups  *services.GroupService
+	Players *services.PlayerService
+	Matches *services.MatchService
+	Stats   *services.StatsService
+
+	DigestDay  time.Weekday
+	DigestHour int
+
+	outbox *outbox // emails waiting for the sender, set by Run
+}
+
+// email is a rendered notification waiting to be sent.
+type email struct {
+	playerID primitive.ObjectID
+	to       string
+	subject  string
+	body     string
+}
+
+// outbox queues the emails for the sender, so that a slow SMTP server never
+// holds up the event loop and its subscription.
+type outbox struct {
+	mu     sync.Mutex
+	emails []email
+	ready  chan struct{} // signalled when emails are queued
+}
+
+func newOutbox() *outbox {
+	return &outbox{ready: make(chan struct{}, 1)}
+}
+
+func (o *outbox) push(e email) {
+	o.mu.Lock()
+	o.emails = append(o.emails, e)
+	o.mu.Unlock()
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) pop() (email, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.emails) == 0 {
+		return email{}, false
+	}
+	e := o.emails[0]
+	o.emails[0] = email{}
+	o.emails = o.emails[1:]
+	return e, true
+}
+
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.emails)
+}
+
+// matchData is passed to the match related templates.
+type matchData struct {
+	Group  string
+	Player models.Player
+	Match  models.MatchResponse
+	Reason string
+}
+
+// digestData is passed to the weekly digest template.
+type digestData struct {
+	Group     string
+	Player    models.Player
+	Standings []services.PlayerStats
+	Played    int64
+}
+
+// Run sends notifications for the bus events and the weekly digests until
+// ctx is done. The event loop only queues the emails, which a separate
+// sender delivers, and the digests are prepared aside, so that the loop
+// keeps up with the bus.
+func (n *Notifier) Run(ctx context.Context, bus *events.Bus) {
+	n.outbox = newOutbox()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n.sendQueued(ctx)
+	}()
+
+	sub := bus.Subscribe("")
+	defer func() { sub.Close() }()
+
+	digest := time.NewTimer(time.Until(nextDigest(time.Now(), n.DigestDay, n.DigestHour)))
+	defer digest.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				slog.Warn("notify: event subscription dropped, resubscribing")
+				sub = bus.Subscribe("")
+				continue
+			}
+			n.handleEvent(ctx, ev)
+		case <-digest.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n.SendDigests(ctx)
+			}()
+			digest.Reset(time.Until(nextDigest(time.Now(), n.DigestDay, n.DigestHour)))
+		}
+	}
+}
+
+// sendQueued sends the queued emails until ctx is done.
+func (n *Notifier) sendQueued(ctx context.Context) {
+	for {
+		for ctx.Err() == nil {
+			e, ok := n.outbox.pop()
+			if !ok {
+				break
+			}
+			n.deliver(e)
+		}
+		select {
+		case <-ctx.Done():
+			if left := n.outbox.len(); left > 0 {
+				slog.Warn("notify: emails not sent before stopping", "count", left)
+			}
+			return
+		case <-n.outbox.ready:
+		}
+	}
+}
+
+// handleEvent notifies the players of the match concerned by an event.
+func (n *Notifier) handleEvent(ctx context.Context, ev events.Event) {
+	var (
+		tmpl    string
+		matchID primitive.ObjectID
+		reason  string
+	)
+	switch ev.Type {
+	case events.MatchCreated:
+		tmpl = TemplateMatchScheduled
+		match, _ := ev.Data.(models.MatchResponse)
+		matchID = match.ID
+	case events.ResultSubmitted, events.ResultDisputed:
+		tmpl = TemplateResultSubmitted
+		data, _ := ev.Data.(map[string]interface{})
+		matchID, _ = data["match_id"].(primitive.ObjectID)
+		if ev.Type == events.ResultDisputed {
+			tmpl = TemplateResultDisputed
+			reason, _ = data["reason"].(string)
+		}
+	default:
+		return
+	}
+
+	match, err := n.Matches.GetMatchResponse(ctx, ev.GroupName, matchID)
+	if err != nil {
+		slog.ErrorContext(ctx, "notify: cannot load match", "match_id", matchID.Hex(), "error", err)
+		return
+	}
+
+	for _, info := range append(append([]models.PlayerInfo{}, match.Team1...), match.Team2...) {
+		player, err := n.Players.GetPlayer(ctx, ev.GroupName, info.ID)
+		if err != nil || player.Contact == nil || player.Contact.Email == "" {
+			continue
+		}
+		n.send(player, tmpl, matchData{
+			Group:  ev.GroupName,
+			Player: player,
+			Match:  match,
+			Reason: reason,
+		})
+	}
+}
+
+// SendDigests emails the standings of every group to its active players.
+func (n *Notifier) SendDigests(ctx context.Context) {
+	groups, err := n.Groups.ListGroups(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "notify: cannot list groups for the digest", "error", err)
+		return
+	}
+
+	for _, g := range groups {
+		players, err := n.Players.ListPlayers(ctx, g.Name, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "notify: cannot list players", "group", g.Name, "error", err)
+			continue
+		}
+
+		var recipients []models.Player
+		for _, p := range players {
+			if !p.Guest && p.Contact != nil && p.Contact.Email != "" {
+				recipients = append(recipients, p)
+			}
+		}
+		if len(recipients) == 0 {
+			continue
+		}
+
+		standings, err := n.Stats.ComputeStats(ctx, g.Name)
+		if err != nil {
+			slog.ErrorContext(ctx, "notify: cannot compute statistics", "group", g.Name, "error", err)
+			continue
+		}
+		sort.SliceStable(standings, func(i, j int) bool {
+			if standings[i].GameWinRate != standings[j].GameWinRate {
+				return standings[i].GameWinRate > standings[j].GameWinRate
+			}
+			return standings[i].TotalGames > standings[j].TotalGames
+		})
+
+		played, err := n.Matches.CountCompletedSince(ctx, g.Name, time.Now().AddDate(0, 0, -7))
+		if err != nil {
+			slog.ErrorContext(ctx, "notify: cannot count matches", "group", g.Name, "error", err)
+		}
+
+		for _, p := range recipients {
+			n.send(p, TemplateWeeklyDigest, digestData{
+				Group:     g.Name,
+				Player:    p,
+				Standings: standings,
+				Played:    played,
+			})
+		}
+	}
+}
+
+// send renders a template for a player and queues it, or emails it right
+// away when Run is not sending the queue.
+func (n *Notifier) send(player models.Player, tmpl string, data interface{}) {
+	subject, body, err := n.Templates.Render(tmpl, data)
+	if err != nil {
+		slog.Error("notify: cannot render template", "template", tmpl, "error", err)
+		return
+	}
+	e := email{playerID: player.ID, to: player.Contact.Email, subject: subject, body: body}
+	if n.outbox == nil {
+		n.deliver(e)
+		return
+	}
+	n.outbox.push(e)
+}
+
+// deliver emails a notification, logging failures.
+func (n *Notifier) deliver(e email) {
+	if err := n.Mailer.Send(e.to, e.subject, e.body); err != nil {
+		slog.Warn("notify: cannot email player", "player_id", e.playerID.Hex(), "error", err)
+	}
+}
+
+// nextDigest returns the next time after now falling on day at hour.
+func nextDigest(now time.Time, day time.Weekday, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(day)-int(now.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
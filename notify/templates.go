@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Template names, one file per notification kind.
+const (
+	TemplateMatchScheduled  = "match_scheduled.tmpl"
+	TemplateResultSubmitted = "result_submitted.tmpl"
+	TemplateResultDisputed  = "result_disputed.tmpl"
+	TemplateWeeklyDigest    = "weekly_digest.tmpl"
+	templateCommon          = "common.tmpl"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Templates renders the notification emails. Each template file defines a
+// "subject" and a "body" template.
+type Templates struct {
+	sets map[string]*template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// LoadTemplates parses the built-in templates, replacing any of them by the
+// file of the same name found in overrideDir, when set.
+func LoadTemplates(overrideDir string) (*Templates, error) {
+	common, err := readTemplate(overrideDir, templateCommon)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{sets: make(map[string]*template.Template)}
+	for _, name := range []string{TemplateMatchScheduled, TemplateResultSubmitted, TemplateResultDisputed, TemplateWeeklyDigest} {
+		text, err := readTemplate(overrideDir, name)
+		if err != nil {
+			return nil, err
+		}
+		set, err := template.New(name).Funcs(templateFuncs).Parse(common)
+		if err == nil {
+			_, err = set.Parse(text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse template %s: %w", name, err)
+		}
+		if set.Lookup("subject") == nil || set.Lookup("body") == nil {
+			return nil, fmt.Errorf("template %s must define a subject and a body", name)
+		}
+		t.sets[name] = set
+	}
+	return t, nil
+}
+
+// Render executes a template and returns the subject and body of the email.
+func (t *Templates) Render(name string, data interface{}) (string, string, error) {
+	set, ok := t.sets[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %s", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := set.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := set.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), strings.TrimLeft(body.String(), "\n"), nil
+}
+
+// readTemplate returns the override of a template if present, or the built-in one.
+func readTemplate(overrideDir, name string) (string, error) {
+	if overrideDir != "" {
+		data, err := os.ReadFile(filepath.Join(overrideDir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	data, err := defaultTemplates.ReadFile("templates/" + name)
+	return string(data), err
+}
@@ -0,0 +1,93 @@
+// Package openapi serves the OpenAPI description of the HTTP API and checks
+// the routes and responses of the server against it.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the parsed OpenAPI document, kept generic so that schemas
+// can be walked as plain JSON values.
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]Schema    `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+}
+
+// Operation is a method of a path.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Response describes a response of an operation by content type.
+type Response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema Schema `json:"schema"`
+	} `json:"content"`
+}
+
+// Schema is a JSON schema object.
+type Schema map[string]interface{}
+
+// Load parses the embedded document.
+func Load() (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Handler serves the document, GET /api/openapi.json
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(spec)
+}
+
+// operation finds the operation of a chi route pattern such as
+// /api/group/{name}/players, or nil when it is not documented.
+func (d *Document) operation(method, pattern string) *Operation {
+	methods, ok := d.Paths[specPath(pattern)]
+	if !ok {
+		return nil
+	}
+	return methods[strings.ToLower(method)]
+}
+
+// response resolves the documented response of a status code, falling back
+// to the default response.
+func (d *Document) response(op *Operation, status int) *Response {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return nil
+	}
+	if resp.Ref != "" {
+		return d.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+	}
+	return resp
+}
+
+// specPath converts a chi route pattern to the path of the document, which
+// is relative to the /api server URL.
+func specPath(pattern string) string {
+	p := strings.TrimPrefix(pattern, "/api")
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
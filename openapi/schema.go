@@ -0,0 +1,209 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validate checks a decoded JSON value against a schema and returns the
+// violations. Only the keywords used by the document are supported.
+func (d *Document) Validate(schema Schema, value interface{}) []string {
+	var errs []string
+	d.validate(schema, value, "$", true, &errs)
+	return errs
+}
+
+func (d *Document) validate(schema Schema, value interface{}, path string, strict bool, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, ok := d.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+		if !ok {
+			fail("unknown schema %s", ref)
+			return
+		}
+		d.validate(target, value, path, strict, errs)
+		return
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && len(schema) > 0 {
+			fail("null is not allowed")
+		}
+		return
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			d.validate(asSchema(s), value, path, false, errs)
+		}
+		if obj, ok := value.(map[string]interface{}); ok && strict {
+			d.checkUnknown(schema, obj, path, errs)
+		}
+		return
+	}
+
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, s := range one {
+			var sub []string
+			d.validate(asSchema(s), value, path, strict, &sub)
+			if len(sub) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("matches %d of the oneOf schemas", matches)
+		}
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			fail("expected object")
+			return
+		}
+		for _, name := range stringList(schema["required"]) {
+			if _, ok := obj[name]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, v := range obj {
+			if s, ok := props[name]; ok {
+				d.validate(asSchema(s), v, path+"."+name, true, errs)
+			}
+		}
+		if strict && props != nil {
+			d.checkUnknown(schema, obj, path, errs)
+		}
+
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			fail("expected array")
+			return
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(arr)) < min {
+			fail("expected at least %v items", min)
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(arr)) > max {
+			fail("expected at most %v items", max)
+		}
+		if items, ok := schema["items"]; ok {
+			for i, v := range arr {
+				d.validate(asSchema(items), v, fmt.Sprintf("%s[%d]", path, i), true, errs)
+			}
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("expected string")
+			return
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(s) {
+				fail("%q does not match %s", s, pattern)
+			}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("%q is not a date-time", s)
+			}
+		}
+
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			fail("expected %s", schema["type"])
+			return
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			fail("expected integer")
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("%v is lower than %v", n, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			fail("%v is greater than %v", n, max)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("expected boolean")
+		}
+	}
+}
+
+// checkUnknown reports the properties of obj that no (sub)schema documents.
+func (d *Document) checkUnknown(schema Schema, obj map[string]interface{}, path string, errs *[]string) {
+	known := map[string]bool{}
+	d.knownProperties(schema, known)
+	var unknown []string
+	for name := range obj {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		*errs = append(*errs, path+": undocumented property "+name)
+	}
+}
+
+// knownProperties collects the properties declared by a schema and the
+// schemas it references or combines.
+func (d *Document) knownProperties(schema Schema, known map[string]bool) {
+	if ref, ok := schema["$ref"].(string); ok {
+		if target, ok := d.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]; ok {
+			d.knownProperties(target, known)
+		}
+		return
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	for name := range props {
+		known[name] = true
+	}
+	all, _ := schema["allOf"].([]interface{})
+	for _, s := range all {
+		d.knownProperties(asSchema(s), known)
+	}
+}
+
+func asSchema(v interface{}) Schema {
+	m, _ := v.(map[string]interface{})
+	return Schema(m)
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if str, ok := s.(string); ok {
+			out = append(out, str)
+		}
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxValidatedBody bounds the response bodies buffered for validation.
+const maxValidatedBody = 1 << 20
+
+// Validator checks the responses of the real handlers against the document.
+// It is meant for development and staging servers, where contract violations
+// are logged as they happen.
+type Validator struct {
+	doc    *Document
+	report func(context.Context, string)
+}
+
+// NewValidator creates a validator reporting violations to the default logger.
+func NewValidator(doc *Document) *Validator {
+	return NewValidatorFunc(doc, func(ctx context.Context, msg string) { slog.WarnContext(ctx, msg) })
+}
+
+// NewValidatorFunc creates a validator reporting violations to report, such
+// as the error function of a contract test.
+func NewValidatorFunc(doc *Document, report func(context.Context, string)) *Validator {
+	return &Validator{doc: doc, report: report}
+}
+
+// CheckRoutes compares the routes of a router with the documented paths and
+// returns the routes missing from either side.
+func (v *Validator) CheckRoutes(routes chi.Routes) []string {
+	routed := map[string]bool{}
+	var problems []string
+	chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/api/") {
+			return nil
+		}
+		key := strings.ToLower(method) + " " + specPath(route)
+		routed[key] = true
+		if v.doc.operation(method, route) == nil {
+			problems = append(problems, "undocumented route "+method+" "+route)
+		}
+		return nil
+	})
+	for path, methods := range v.doc.Paths {
+		for method := range methods {
+			if !routed[method+" "+path] {
+				problems = append(problems, "documented route not served: "+strings.ToUpper(method)+" /api"+path)
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Middleware validates the JSON responses of the documented API routes. It
+// must be installed on the chi router, so that the route pattern is known
+// once the handler returns.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.hijacked {
+			return
+		}
+
+		pattern := chi.RouteContext(r.Context()).RoutePattern()
+		for _, problem := range v.check(r.Method, pattern, rec) {
+			v.report(r.Context(), fmt.Sprintf("openapi: %s %s (%s): %s", r.Method, r.URL.Path, pattern, problem))
+		}
+	})
+}
+
+// check validates a recorded response.
+func (v *Validator) check(method, pattern string, rec *recorder) []string {
+	if pattern == "" || strings.HasSuffix(pattern, "*") {
+		// Not routed, chi answered 404 or 405
+		return nil
+	}
+	op := v.doc.operation(method, pattern)
+	if op == nil {
+		return []string{"undocumented route"}
+	}
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := v.doc.response(op, status)
+	if resp == nil {
+		return []string{fmt.Sprintf("undocumented status %d", status)}
+	}
+	if len(resp.Content) == 0 {
+		return nil
+	}
+
+	ctype, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	content, ok := resp.Content[ctype]
+	if !ok {
+		// Media ranges such as image/*
+		for documented, c := range resp.Content {
+			if strings.HasSuffix(documented, "/*") && strings.HasPrefix(ctype, strings.TrimSuffix(documented, "*")) {
+				content, ok = c, true
+			}
+		}
+	}
+	if !ok {
+		return []string{fmt.Sprintf("undocumented content type %q for status %d", ctype, status)}
+	}
+	if ctype != "application/json" || rec.truncated {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(rec.body.Bytes(), &body); err != nil {
+		return []string{"invalid JSON body: " + err.Error()}
+	}
+	return v.doc.Validate(content.Schema, body)
+}
+
+// recorder passes the response through while keeping a copy of JSON bodies.
+type recorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+	hijacked  bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		if rec.body.Len()+len(b) > maxValidatedBody {
+			rec.truncated = true
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush keeps the Server-Sent Events streams working.
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps the WebSocket upgrades working.
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	rec.hijacked = true
+	return h.Hijack()
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Memory forgets the idle keys.
+const sweepInterval = time.Minute
+
+// Memory is a Backend keeping the state in the process, so that each
+// instance of the server limits the clients on its own.
+type Memory struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failures
+	locks    map[string]time.Time
+	swept    time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket is full again, to forget it
+}
+
+type failures struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]*failures),
+		locks:    make(map[string]time.Time),
+		swept:    time.Now(),
+	}
+}
+
+func (m *Memory) Take(key string, perMinute, burst int) time.Duration {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	rate := float64(perMinute) / float64(time.Minute) // tokens per nanosecond
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.updated)) * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate))
+	return 0
+}
+
+func (m *Memory) Fail(key string, window time.Duration) int {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(now)
+
+	f, ok := m.failures[key]
+	if !ok || now.After(f.expires) {
+		f = &failures{}
+		m.failures[key] = f
+	}
+	f.count++
+	f.expires = now.Add(window)
+	return f.count
+}
+
+func (m *Memory) Lock(key string, until time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locks[key] = until
+}
+
+func (m *Memory) LockedUntil(key string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locks[key]
+}
+
+// sweepLocked forgets the full buckets, the expired failures and the past
+// lockouts, at most once per sweepInterval.
+func (m *Memory) sweepLocked(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if now.After(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	for key, f := range m.failures {
+		if now.After(f.expires) {
+			delete(m.failures, key)
+		}
+	}
+	for key, until := range m.locks {
+		if now.After(until) {
+			delete(m.locks, key)
+		}
+	}
+}
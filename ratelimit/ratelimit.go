@@ -0,0 +1,142 @@
+// Package ratelimit throttles the password guesses: it limits the rate of
+// the authenticated requests per client and per group, and locks out the
+// clients failing too many passwords, for exponentially longer each time.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Backend keeps the state of the limiter. Memory is the default; a shared
+// backend would limit the clients across the instances of the server.
+type Backend interface {
+	// Take removes a token from the bucket of key, holding up to burst
+	// tokens and refilled with perMinute tokens a minute, and returns how
+	// long to wait for one when it is empty.
+	Take(key string, perMinute, burst int) time.Duration
+
+	// Fail counts a failure of key and returns the failures counted since
+	// the last period of window without any.
+	Fail(key string, window time.Duration) int
+
+	// Lock locks key out until the given time.
+	Lock(key string, until time.Time)
+
+	// LockedUntil returns the end of the lockout of key, zero if none.
+	LockedUntil(key string) time.Time
+}
+
+// Options configures a Limiter. Zero rates disable their limit.
+type Options struct {
+	ClientPerMinute int // authenticated requests of a client
+	ClientBurst     int
+	GroupPerMinute  int // failed passwords of a group, from any client
+	GroupBurst      int
+
+	// MaxFailures failed passwords within FailureWindow lock a client out
+	// for Lockout, doubled at each further failure up to MaxLockout. Zero
+	// disables the lockouts.
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+}
+
+// DefaultOptions are the limits of the default configuration.
+var DefaultOptions = Options{
+	ClientPerMinute: 60,
+	ClientBurst:     20,
+	GroupPerMinute:  300,
+	GroupBurst:      60,
+	MaxFailures:     5,
+	FailureWindow:   time.Hour,
+	Lockout:         time.Minute,
+	MaxLockout:      30 * time.Minute,
+}
+
+// Limiter decides whether clients may try a password. A nil *Limiter allows
+// every attempt.
+type Limiter struct {
+	opts    Options
+	backend Backend
+	audit   *slog.Logger
+}
+
+// New returns a limiter keeping its state in backend and logging the
+// lockouts to audit, when not nil.
+func New(opts Options, backend Backend, audit *slog.Logger) *Limiter {
+	return &Limiter{opts: opts, backend: backend, audit: audit}
+}
+
+// Allow returns how long a client must wait before a request to a scope,
+// such as a group, zero when it may proceed now. Locked out clients wait
+// for the end of their lockout, and every client waits while the scope has
+// failed too many passwords.
+func (l *Limiter) Allow(client, scope string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if until := l.backend.LockedUntil("lock:" + client); !until.IsZero() {
+		if wait := time.Until(until); wait > 0 {
+			return wait
+		}
+	}
+	if l.opts.ClientPerMinute > 0 {
+		if wait := l.backend.Take("client:"+client, l.opts.ClientPerMinute, l.opts.ClientBurst); wait > 0 {
+			return wait
+		}
+	}
+	if scope != "" {
+		if until := l.backend.LockedUntil("throttle:" + scope); !until.IsZero() {
+			if wait := time.Until(until); wait > 0 {
+				return wait
+			}
+		}
+	}
+	return 0
+}
+
+// Fail records a failed password of a client for a scope and returns the
+// lockout it caused, zero if none. Clients are locked out as a whole rather
+// than per scope, so that they cannot spread their guesses over the groups.
+// The scopes are only throttled once the failures of all the clients exceed
+// their rate: the requests with the right password never count against it,
+// so that the members of a busy group are not held back by each other.
+func (l *Limiter) Fail(ctx context.Context, client, scope string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	if l.opts.GroupPerMinute > 0 && scope != "" {
+		if wait := l.backend.Take("scope:"+scope, l.opts.GroupPerMinute, l.opts.GroupBurst); wait > 0 {
+			l.backend.Lock("throttle:"+scope, time.Now().Add(wait))
+		}
+	}
+	if l.opts.MaxFailures <= 0 {
+		return 0
+	}
+	failures := l.backend.Fail("fail:"+client, l.opts.FailureWindow)
+	if failures < l.opts.MaxFailures {
+		return 0
+	}
+
+	lockout := l.opts.Lockout
+	for i := l.opts.MaxFailures; i < failures && lockout < l.opts.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.opts.MaxLockout {
+		lockout = l.opts.MaxLockout
+	}
+	l.backend.Lock("lock:"+client, time.Now().Add(lockout))
+
+	if l.audit != nil {
+		l.audit.WarnContext(ctx, "lockout",
+			"client", client,
+			"scope", scope,
+			"failures", failures,
+			"lockout", lockout.String(),
+		)
+	}
+	return lockout
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/p4u/padelfriends/config"
+	"github.com/p4u/padelfriends/db"
+	"github.com/p4u/padelfriends/events"
+	"github.com/p4u/padelfriends/handlers"
+	"github.com/p4u/padelfriends/live"
+	"github.com/p4u/padelfriends/migrations"
+	"github.com/p4u/padelfriends/openapi"
+	"github.com/p4u/padelfriends/services"
+)
+
+// TestRoutesDocumented checks that every API route is documented and every
+// documented route is served. The handlers are not called, so they need no
+// database.
+func TestRoutesDocumented(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(&handlers.GroupHandler{}, &handlers.PlayerHandler{}, &handlers.MatchHandler{},
+		&handlers.StatsHandler{}, &handlers.IdentityHandler{}, &handlers.TeamHandler{},
+		&handlers.EventsHandler{}, &handlers.LiveHandler{}, &handlers.WebhookHandler{},
+		&handlers.BotHandler{}, &handlers.HealthHandler{}, http.NotFoundHandler(), nil, nil)
+
+	for _, problem := range openapi.NewValidator(doc).CheckRoutes(r) {
+		t.Error(problem)
+	}
+}
+
+// TestResponsesDocumented calls the real handlers and checks their responses
+// against the document. It needs a MongoDB replica set, given by MONGODB_URI,
+// and uses a throwaway database.
+func TestResponsesDocumented(t *testing.T) {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI is not set")
+	}
+	ctx := context.Background()
+
+	mdb, err := db.Connect(config.MongoDBConfig{
+		URI:            uri,
+		Database:       fmt.Sprintf("padelfriends_test_%d", time.Now().UnixNano()),
+		ConnectTimeout: 10 * time.Second,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mdb.Database.Drop(ctx)
+		mdb.Client.Disconnect(ctx)
+	})
+	migrator := migrations.New(mdb.Database)
+	if _, err := migrator.Up(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus(events.DefaultHistorySize)
+	groupService := services.NewGroupService(mdb.Database)
+	webhookService := services.NewWebhookService(mdb.Database, false)
+	playerService := services.NewPlayerService(mdb.Database, bus, webhookService)
+	matchService := services.NewMatchService(mdb.Database, bus, webhookService)
+	statsService := services.NewStatsService(mdb.Database)
+	identityService := services.NewIdentityService(mdb.Database)
+	avatarStore, err := services.NewAvatarStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := openapi.NewValidatorFunc(doc, func(_ context.Context, msg string) { t.Error(msg) })
+
+	r := New(
+		&handlers.GroupHandler{GroupService: groupService},
+		&handlers.PlayerHandler{GroupService: groupService, PlayerService: playerService, AvatarStore: avatarStore},
+		&handlers.MatchHandler{GroupService: groupService, MatchService: matchService},
+		&handlers.StatsHandler{GroupService: groupService, StatsService: statsService},
+		&handlers.IdentityHandler{
+			GroupService:    groupService,
+			IdentityService: identityService,
+			MatchService:    matchService,
+			StatsService:    statsService,
+		},
+		&handlers.TeamHandler{GroupService: groupService, TeamService: services.NewTeamService(mdb.Database)},
+		&handlers.EventsHandler{Bus: bus},
+		&handlers.LiveHandler{GroupService: groupService, MatchService: matchService, Hub: live.NewHub(matchService)},
+		&handlers.WebhookHandler{GroupService: groupService, WebhookService: webhookService},
+		&handlers.BotHandler{GroupService: groupService, BotService: services.NewBotService(mdb.Database)},
+		&handlers.HealthHandler{Client: mdb.Client, Migrator: migrator, Started: time.Now()},
+		http.NotFoundHandler(), nil, nil, validator.Middleware)
+
+	// call sends a request with the group password and checks its status. The
+	// validator reports the responses that do not match the document.
+	call := func(method, path string, body interface{}, status int) map[string]interface{} {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Group-Password", "contract-secret")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	call("POST", "/api/group", map[string]string{"name": "contract", "password": "contract-secret"}, http.StatusCreated)
+	call("POST", "/api/group/contract/authenticate", nil, http.StatusOK)
+	call("GET", "/api/groups", nil, http.StatusOK)
+
+	var playerIDs []string
+	for _, name := range []string{"Ana", "Bea", "Carla", "Dani"} {
+		p := call("POST", "/api/group/contract/players", map[string]string{"name": name}, http.StatusCreated)
+		playerIDs = append(playerIDs, p["id"].(string))
+	}
+	call("GET", "/api/group/contract/players", nil, http.StatusOK)
+	call("PUT", "/api/group/contract/players/"+playerIDs[0]+"/attributes",
+		map[string]string{"preferred_side": "drive"}, http.StatusOK)
+	call("PATCH", "/api/v2/groups/contract/players/"+playerIDs[1],
+		map[string]interface{}{"name": "Beatriz", "attributes": map[string]float64{"level": 3}}, http.StatusOK)
+	call("GET", "/api/v2/groups/contract/players/"+playerIDs[1], nil, http.StatusOK)
+
+	m := call("POST", "/api/group/contract/matches", map[string]interface{}{"player_ids": playerIDs}, http.StatusCreated)
+	matchID := m["id"].(string)
+	call("POST", "/api/group/contract/matches/"+matchID+"/results",
+		map[string]int{"score_team1": 6, "score_team2": 4}, http.StatusOK)
+	call("POST", "/api/group/contract/matches/"+matchID+"/dispute",
+		map[string]string{"reason": "wrong score"}, http.StatusOK)
+	call("POST", "/api/group/contract/matches/batch",
+		map[string]interface{}{"matches": [][]string{playerIDs}}, http.StatusCreated)
+	call("GET", "/api/group/contract/matches", nil, http.StatusOK)
+	call("GET", "/api/v2/groups/contract/matches", nil, http.StatusOK)
+	call("GET", "/api/v2/groups/contract/matches/"+matchID, nil, http.StatusOK)
+	call("GET", "/api/group/contract/statistics", nil, http.StatusOK)
+
+	// The members of another group cannot change the matches of this one
+	call("POST", "/api/group", map[string]string{"name": "other", "password": "contract-secret"}, http.StatusCreated)
+	pending := call("POST", "/api/group/contract/matches", map[string]interface{}{"player_ids": playerIDs}, http.StatusCreated)
+	pendingID := pending["id"].(string)
+	call("POST", "/api/group/other/matches/"+pendingID+"/results",
+		map[string]int{"score_team1": 6, "score_team2": 0}, http.StatusNotFound)
+	call("POST", "/api/group/other/matches/"+pendingID+"/cancel", nil, http.StatusNotFound)
+	call("DELETE", "/api/v2/groups/other/matches/"+pendingID, nil, http.StatusNotFound)
+	call("POST", "/api/group/contract/matches/"+pendingID+"/cancel", nil, http.StatusOK)
+
+	identity := call("POST", "/api/identity", map[string]string{"name": "Ana", "password": "identity-secret"}, http.StatusCreated)
+	call("POST", "/api/group/contract/players/"+playerIDs[0]+"/link",
+		map[string]string{"identity_id": identity["id"].(string), "identity_password": "identity-secret"}, http.StatusOK)
+
+	call("POST", "/api/group/contract/webhooks",
+		map[string]interface{}{"url": "https://203.0.113.10/hook", "events": []string{"match.created"}}, http.StatusCreated)
+	call("GET", "/api/group/contract/webhooks/deliveries", nil, http.StatusOK)
+	call("POST", "/api/group/contract/bot/link-token", nil, http.StatusCreated)
+	call("GET", "/api/health", nil, http.StatusOK)
+
+	// Error responses are documented too
+	call("GET", "/api/v2/groups/missing/players", nil, http.StatusNotFound)
+	call("POST", "/api/group/contract/matches", map[string]interface{}{"player_ids": playerIDs[:3]}, http.StatusBadRequest)
+	call("GET", "/api/v2/groups/contract/matches/"+playerIDs[0], nil, http.StatusNotFound)
+	call("GET", "/api/group/contract/matches?page=4611686018427387904&pageSize=100", nil, http.StatusBadRequest)
+	call("POST", "/api/group/contract/webhooks",
+		map[string]interface{}{"url": "http://169.254.169.254/latest"}, http.StatusBadRequest)
+}
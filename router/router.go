@@ -1,11 +1,14 @@
 package router
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/p4u/padelfriends/handlers"
+	"github.com/p4u/padelfriends/logging"
+	"github.com/p4u/padelfriends/openapi"
 )
 
 func New(
@@ -13,69 +16,175 @@ func New(
 	playerHandler *handlers.PlayerHandler,
 	matchHandler *handlers.MatchHandler,
 	statsHandler *handlers.StatsHandler,
-) http.Handler {
+	identityHandler *handlers.IdentityHandler,
+	teamHandler *handlers.TeamHandler,
+	eventsHandler *handlers.EventsHandler,
+	liveHandler *handlers.LiveHandler,
+	webhookHandler *handlers.WebhookHandler,
+	botHandler *handlers.BotHandler,
+	healthHandler *handlers.HealthHandler,
+	staticHandler http.Handler,
+	accessLog *slog.Logger,
+	rateLimit func(http.Handler) http.Handler,
+	middlewares ...func(http.Handler) http.Handler,
+) chi.Router {
+	// Rate limit of the password guesses, none when nil
+	if rateLimit == nil {
+		rateLimit = func(next http.Handler) http.Handler { return next }
+	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(logging.AssignRequestID)
+	if accessLog != nil {
+		r.Use(logging.AccessLog(accessLog))
+	}
 	r.Use(middleware.Recoverer)
+	r.Use(middlewares...)
 
 	// API routes
 	r.Route("/api", func(r chi.Router) {
+		// Unknown API paths must not fall through to the web application
+		r.NotFound(handlers.APINotFound)
+		r.MethodNotAllowed(handlers.APIMethodNotAllowed)
+
 		// Public endpoints (no auth required)
 		r.Get("/groups", groupHandler.ListGroups)
-		r.Get("/group/{name}", groupHandler.GetGroupByName)
-		r.Get("/group/byname/{name}", groupHandler.GetGroupByName)
+		r.With(rateLimit).Get("/group/byname/{name}", groupHandler.GetGroupByName)
 		r.Post("/group", groupHandler.CreateGroup) // Added missing endpoint
 
 		r.Route("/group/{name}", func(r chi.Router) {
+			r.Use(rateLimit)
+
 			// Public endpoints (no auth required)
+			r.Get("/", groupHandler.GetGroupByName)
 			r.Get("/matches", matchHandler.ListMatches)
 			r.Get("/players", playerHandler.ListPlayers)
+			r.Get("/players/{player_id}/avatar", playerHandler.GetAvatar)
+			r.Post("/teams/suggest", teamHandler.SuggestTeams)
 			r.Get("/statistics", statsHandler.GetStatistics)
 			r.Get("/export/csv", groupHandler.ExportGroupMatchesCSV)
+			r.Get("/events", eventsHandler.StreamEvents)
+			r.Get("/matches/{match_id}/live", liveHandler.ServeLive)
 
 			// Authentication endpoint
 			r.Post("/authenticate", groupHandler.AuthenticateGroup)
 
 			// Protected endpoints (auth required)
 			r.Group(func(r chi.Router) {
-				r.Use(requireAuth)
+				r.Use(handlers.RequireAuth)
+				r.Put("/settings", groupHandler.UpdateSettings)
 				r.Post("/players", playerHandler.AddPlayer)
+				r.Put("/players/{player_id}", playerHandler.RenamePlayer)
+				r.Delete("/players/{player_id}", playerHandler.DeletePlayer)
+				r.Put("/players/{player_id}/attributes", playerHandler.UpdateAttributes)
+				r.Post("/players/{player_id}/avatar", playerHandler.UploadAvatar)
+				r.Post("/players/{player_id}/deactivate", playerHandler.DeactivatePlayer)
+				r.Post("/players/{player_id}/activate", playerHandler.ActivatePlayer)
+				r.Post("/players/{player_id}/merge", playerHandler.MergePlayer)
+				r.Post("/players/{player_id}/link", identityHandler.LinkGroupPlayer)
+				r.Post("/players/{player_id}/unlink", identityHandler.UnlinkGroupPlayer)
 				r.Post("/matches", matchHandler.CreateMatch)
 				r.Post("/matches/batch", matchHandler.CreateMatches)
+				r.Post("/matches/generate", teamHandler.GenerateMatches)
 				r.Post("/matches/{match_id}/cancel", matchHandler.CancelMatch)
 				r.Post("/matches/{match_id}/results", matchHandler.SubmitResults)
+				r.Post("/matches/{match_id}/dispute", matchHandler.DisputeResult)
+
+				r.Get("/webhooks", webhookHandler.ListWebhooks)
+				r.Post("/webhooks", webhookHandler.CreateWebhook)
+				r.Delete("/webhooks/{webhook_id}", webhookHandler.DeleteWebhook)
+				r.Get("/webhooks/deliveries", webhookHandler.ListDeliveries)
+				r.Post("/webhooks/deliveries/{delivery_id}/retry", webhookHandler.RetryDelivery)
+
+				r.Post("/bot/link-token", botHandler.CreateLinkToken)
 			})
 		})
 
-		// Health check
-		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("ok"))
+		// Global player identities, authenticated with the identity password
+		r.Post("/identity", identityHandler.CreateIdentity)
+		r.Route("/identity/{identity_id}", func(r chi.Router) {
+			r.Use(rateLimit)
+			r.Get("/", identityHandler.GetIdentity)
+			r.Get("/matches", identityHandler.ListMatches)
+			r.Get("/statistics", identityHandler.GetStatistics)
+			r.Delete("/players/{player_id}", identityHandler.UnlinkPlayer)
+		})
+
+		// Version 2 of the API, naming groups by their slug
+		r.Route("/v2", func(r chi.Router) {
+			r.Get("/groups", groupHandler.ListGroups)
+			r.Post("/groups", groupHandler.CreateGroup)
+
+			r.Route("/groups/{group}", func(r chi.Router) {
+				r.Use(handlers.ResolveGroup(groupHandler.GroupService))
+				r.Use(rateLimit)
+
+				// Public endpoints (no auth required)
+				r.Get("/", groupHandler.GetGroupByName)
+				r.Get("/players", playerHandler.ListPlayers)
+				r.Get("/players/{player_id}", playerHandler.GetPlayer)
+				r.Get("/players/{player_id}/avatar", playerHandler.GetAvatar)
+				r.Get("/matches", matchHandler.ListMatchesPage)
+				r.Get("/matches/{match_id}", matchHandler.GetMatch)
+				r.Get("/matches/{match_id}/live", liveHandler.ServeLive)
+				r.Post("/teams/suggest", teamHandler.SuggestTeams)
+				r.Get("/statistics", statsHandler.GetStatistics)
+				r.Get("/export/csv", groupHandler.ExportGroupMatchesCSV)
+				r.Get("/events", eventsHandler.StreamEvents)
+				r.Post("/authenticate", groupHandler.AuthenticateGroup)
+
+				// Protected endpoints (auth required)
+				r.Group(func(r chi.Router) {
+					r.Use(handlers.RequireAuth)
+					r.Patch("/", groupHandler.PatchGroup)
+					r.Post("/players", playerHandler.AddPlayer)
+					r.Patch("/players/{player_id}", playerHandler.PatchPlayer)
+					r.Delete("/players/{player_id}", playerHandler.DeletePlayer)
+					r.Put("/players/{player_id}/avatar", playerHandler.UploadAvatar)
+					r.Post("/players/{player_id}/merge", playerHandler.MergePlayer)
+					r.Put("/players/{player_id}/identity", identityHandler.LinkGroupPlayer)
+					r.Delete("/players/{player_id}/identity", identityHandler.UnlinkGroupPlayer)
+					r.Post("/matches", matchHandler.CreateMatch)
+					r.Post("/matches/batch", matchHandler.CreateMatches)
+					r.Post("/matches/generate", teamHandler.GenerateMatches)
+					r.Patch("/matches/{match_id}", matchHandler.PatchMatch)
+					r.Delete("/matches/{match_id}", matchHandler.DeleteMatch)
+
+					r.Get("/webhooks", webhookHandler.ListWebhooks)
+					r.Post("/webhooks", webhookHandler.CreateWebhook)
+					r.Delete("/webhooks/{webhook_id}", webhookHandler.DeleteWebhook)
+					r.Get("/webhooks/deliveries", webhookHandler.ListDeliveries)
+					r.Post("/webhooks/deliveries/{delivery_id}/retry", webhookHandler.RetryDelivery)
+
+					r.Post("/bot/link-token", botHandler.CreateLinkToken)
+				})
+			})
+
+			r.Post("/identities", identityHandler.CreateIdentity)
+			r.Route("/identities/{identity_id}", func(r chi.Router) {
+				r.Use(rateLimit)
+				r.Get("/", identityHandler.GetIdentity)
+				r.Get("/matches", identityHandler.ListMatches)
+				r.Get("/statistics", identityHandler.GetStatistics)
+				r.Delete("/players/{player_id}", identityHandler.UnlinkPlayer)
+			})
 		})
+
+		// Health check, the readiness of the probes below
+		r.Get("/health", healthHandler.Readyz)
+		r.Get("/health/details", healthHandler.Details)
+
+		// API description
+		r.Get("/openapi.json", openapi.Handler)
 	})
 
+	// Probes of the orchestrators and load balancers
+	r.Get("/livez", healthHandler.Livez)
+	r.Get("/readyz", healthHandler.Readyz)
+
 	// Serve static files
-	r.HandleFunc("/*", handlers.StaticHandler)
-	r.HandleFunc("/", handlers.StaticHandler)
+	r.Handle("/*", staticHandler)
+	r.Handle("/", staticHandler)
 
 	return r
 }
-
-// requireAuth middleware checks for password in query params
-func requireAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		password := r.URL.Query().Get("password")
-		if password == "" {
-			http.Error(w, "Authentication required", http.StatusUnauthorized)
-			return
-		}
-
-		groupName := chi.URLParam(r, "name")
-		if groupName == "" {
-			http.Error(w, "Group name required", http.StatusBadRequest)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,257 @@
+// Package scoring implements point-by-point padel scoring: 15/30/40 games
+// with deuce or golden point, sets with a tie-break, and best-of-N matches.
+package scoring
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Format describes the rules of a match.
+type Format struct {
+	Sets           int  `json:"sets"`             // sets of the match, best of 1, 3 or 5
+	GamesPerSet    int  `json:"games_per_set"`    // games needed to win a set, usually 6
+	GoldenPoint    bool `json:"golden_point"`     // deciding point at 40-40 instead of advantages
+	TieBreakPoints int  `json:"tie_break_points"` // points needed to win the tie-break, usually 7
+}
+
+// DefaultFormat is a single set to six games with advantages and a tie-break at 6-6.
+var DefaultFormat = Format{Sets: 1, GamesPerSet: 6, TieBreakPoints: 7}
+
+// Validate checks that the format can be played.
+func (f Format) Validate() error {
+	switch f.Sets {
+	case 1, 3, 5:
+	default:
+		return errors.New("sets must be 1, 3 or 5")
+	}
+	if f.GamesPerSet < 1 || f.GamesPerSet > 9 {
+		return errors.New("games per set must be between 1 and 9")
+	}
+	if f.TieBreakPoints < 1 || f.TieBreakPoints > 15 {
+		return errors.New("tie-break points must be between 1 and 15")
+	}
+	return nil
+}
+
+// SetWinner returns the team winning a finished set, 1 or 2, and 0 when the
+// set is not finished or cannot end at these games: 6-4, 7-5 and the
+// tie-break 7-6 are won, 6-5 is not finished and 8-6 is impossible.
+func (f Format) SetWinner(games1, games2 int) int {
+	if !f.ValidSet(games1, games2) {
+		return 0
+	}
+	switch {
+	case games1 == f.GamesPerSet+1 || (games1 == f.GamesPerSet && games1-games2 >= 2):
+		return 1
+	case games2 == f.GamesPerSet+1 || (games2 == f.GamesPerSet && games2-games1 >= 2):
+		return 2
+	}
+	return 0
+}
+
+// Result is the outcome of a match decided set by set.
+type Result struct {
+	Sets    [][2]int `json:"sets"`
+	SetsWon [2]int   `json:"sets_won"`
+	Winner  int      `json:"winner"`
+}
+
+// Decide checks the games of the sets of a finished match and returns its
+// outcome: every set must be won, and the last one must decide the match.
+// The live scorer and the results reported set by set share it, so that a
+// match is always won by the team winning the most sets.
+func (f Format) Decide(sets [][2]int) (Result, error) {
+	if err := f.Validate(); err != nil {
+		return Result{}, err
+	}
+	r := Result{Sets: sets}
+	for i, set := range sets {
+		if r.Winner != 0 {
+			return Result{}, fmt.Errorf("set %d is played after the match is decided", i+1)
+		}
+		w := f.SetWinner(set[0], set[1])
+		if w == 0 {
+			return Result{}, fmt.Errorf("set %d is not finished at %d-%d", i+1, set[0], set[1])
+		}
+		r.SetsWon[w-1]++
+		if r.SetsWon[w-1] > f.Sets/2 {
+			r.Winner = w
+		}
+	}
+	if r.Winner == 0 {
+		return Result{}, errors.New("the match is not decided yet")
+	}
+	return r, nil
+}
+
+// ValidSet reports whether a set can stand at the given games, finished or
+// not: 6-4, 7-5 and 7-6 are valid, as is 4-3 when the time ran out, but 8-6
+// or 7-3 are not.
+func (f Format) ValidSet(games1, games2 int) bool {
+	if games1 < 0 || games2 < 0 {
+		return false
+	}
+	if games1 < games2 {
+		games1, games2 = games2, games1
+	}
+	if games1 <= f.GamesPerSet {
+		return true
+	}
+	return games1 == f.GamesPerSet+1 && games2 >= f.GamesPerSet-1 && games2 <= f.GamesPerSet
+}
+
+// State is a snapshot of the score.
+type State struct {
+	Format   Format    `json:"format"`
+	Sets     [][2]int  `json:"sets"`     // games of every set played so far, the current one last
+	SetsWon  [2]int    `json:"sets_won"` // sets won by team 1 and team 2
+	Points   [2]string `json:"points"`   // current game score as displayed, e.g. "30", "40", "AD"
+	TieBreak bool      `json:"tie_break"`
+	Finished bool      `json:"finished"`
+	Winner   int       `json:"winner,omitempty"` // 1 or 2 once finished
+	Played   int       `json:"points_played"`
+}
+
+// Match keeps the list of points won and derives the score from it, which
+// makes undoing a point a matter of replaying one point less.
+type Match struct {
+	format Format
+	points []int
+	state  State
+}
+
+// NewMatch starts a match with the given format.
+func NewMatch(f Format) (*Match, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	m := &Match{format: f}
+	m.replay()
+	return m, nil
+}
+
+// PointWon records a point won by team 1 or 2.
+func (m *Match) PointWon(team int) error {
+	if team != 1 && team != 2 {
+		return errors.New("team must be 1 or 2")
+	}
+	if m.state.Finished {
+		return errors.New("match already finished")
+	}
+	m.points = append(m.points, team)
+	m.replay()
+	return nil
+}
+
+// Undo removes the last recorded point.
+func (m *Match) Undo() error {
+	if len(m.points) == 0 {
+		return errors.New("no points to undo")
+	}
+	m.points = m.points[:len(m.points)-1]
+	m.replay()
+	return nil
+}
+
+// State returns the current score.
+func (m *Match) State() State {
+	s := m.state
+	s.Sets = append([][2]int(nil), m.state.Sets...)
+	return s
+}
+
+// Games returns the games won by each team over all sets.
+func (m *Match) Games() (int, int) {
+	var g1, g2 int
+	for _, set := range m.state.Sets {
+		g1 += set[0]
+		g2 += set[1]
+	}
+	return g1, g2
+}
+
+// replay recomputes the state from the recorded points.
+func (m *Match) replay() {
+	f := m.format
+	s := State{Format: f, Sets: [][2]int{{0, 0}}}
+	var pts [2]int
+
+	for _, team := range m.points {
+		w, l := team-1, 2-team
+		pts[w]++
+		set := &s.Sets[len(s.Sets)-1]
+
+		if !gameWon(pts, w, l, s.TieBreak, f) {
+			continue
+		}
+
+		// Game (or tie-break) won
+		pts = [2]int{}
+		set[w]++
+		if !setWon(*set, w, l, s.TieBreak, f) {
+			s.TieBreak = set[0] == f.GamesPerSet && set[1] == f.GamesPerSet
+			continue
+		}
+
+		// Set won
+		s.TieBreak = false
+		s.SetsWon[w]++
+		if s.SetsWon[w] > f.Sets/2 {
+			s.Finished = true
+			s.Winner = team
+			break
+		}
+		s.Sets = append(s.Sets, [2]int{0, 0})
+	}
+
+	s.Points = displayPoints(pts, s.TieBreak, f)
+	s.Played = len(m.points)
+	m.state = s
+}
+
+// gameWon reports whether w has won the current game or tie-break.
+func gameWon(pts [2]int, w, l int, tieBreak bool, f Format) bool {
+	if tieBreak {
+		return pts[w] >= f.TieBreakPoints && pts[w]-pts[l] >= 2
+	}
+	if f.GoldenPoint {
+		return pts[w] >= 4
+	}
+	return pts[w] >= 4 && pts[w]-pts[l] >= 2
+}
+
+// setWon reports whether w has won the current set after winning a game.
+func setWon(games [2]int, w, l int, tieBreak bool, f Format) bool {
+	if tieBreak {
+		return true
+	}
+	return games[w] >= f.GamesPerSet && games[w]-games[l] >= 2
+}
+
+// displayPoints renders the points of the current game.
+func displayPoints(pts [2]int, tieBreak bool, f Format) [2]string {
+	if tieBreak {
+		return [2]string{strconv.Itoa(pts[0]), strconv.Itoa(pts[1])}
+	}
+	names := []string{"0", "15", "30", "40"}
+	if pts[0] >= 3 && pts[1] >= 3 && !f.GoldenPoint {
+		switch {
+		case pts[0] == pts[1]:
+			return [2]string{"40", "40"}
+		case pts[0] > pts[1]:
+			return [2]string{"AD", "40"}
+		default:
+			return [2]string{"40", "AD"}
+		}
+	}
+	var d [2]string
+	for i, p := range pts {
+		if p > 3 {
+			p = 3
+		}
+		d[i] = names[p]
+	}
+	return d
+}
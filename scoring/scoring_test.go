@@ -0,0 +1,337 @@
+package scoring
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var goldenPointFormat = Format{Sets: 1, GamesPerSet: 6, GoldenPoint: true, TieBreakPoints: 7}
+
+// play records the points of seq, the digits of the winning teams; other
+// characters only separate the games for readability.
+func play(t *testing.T, m *Match, seq string) {
+	t.Helper()
+	for _, c := range seq {
+		if c != '1' && c != '2' {
+			continue
+		}
+		if err := m.PointWon(int(c - '0')); err != nil {
+			t.Fatalf("point %c: %v", c, err)
+		}
+	}
+}
+
+// games returns the points of n love games won by team.
+func games(team string, n int) string {
+	return strings.Repeat(strings.Repeat(team, 4)+" ", n)
+}
+
+// alternate returns the points of n pairs of love games, won by team 1 then
+// team 2.
+func alternate(n int) string {
+	return strings.Repeat(games("1", 1)+games("2", 1), n)
+}
+
+func newMatch(t *testing.T, f Format) *Match {
+	t.Helper()
+	m, err := NewMatch(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFormatValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		ok     bool
+	}{
+		{"default", DefaultFormat, true},
+		{"best of 5", Format{Sets: 5, GamesPerSet: 6, TieBreakPoints: 7}, true},
+		{"two sets", Format{Sets: 2, GamesPerSet: 6, TieBreakPoints: 7}, false},
+		{"no games", Format{Sets: 1, GamesPerSet: 0, TieBreakPoints: 7}, false},
+		{"too many games", Format{Sets: 1, GamesPerSet: 10, TieBreakPoints: 7}, false},
+		{"no tie-break points", Format{Sets: 1, GamesPerSet: 6}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.format.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidSet(t *testing.T) {
+	tests := []struct {
+		games [2]int
+		valid bool
+	}{
+		{[2]int{6, 4}, true},
+		{[2]int{4, 6}, true},
+		{[2]int{6, 0}, true},
+		{[2]int{4, 3}, true},
+		{[2]int{6, 6}, true},
+		{[2]int{7, 5}, true},
+		{[2]int{6, 7}, true},
+		{[2]int{7, 4}, false},
+		{[2]int{7, 7}, false},
+		{[2]int{8, 6}, false},
+		{[2]int{99, 0}, false},
+		{[2]int{-1, 6}, false},
+	}
+	for _, tt := range tests {
+		if got := DefaultFormat.ValidSet(tt.games[0], tt.games[1]); got != tt.valid {
+			t.Errorf("ValidSet(%d, %d) = %v, want %v", tt.games[0], tt.games[1], got, tt.valid)
+		}
+	}
+}
+
+func TestSetWinner(t *testing.T) {
+	tests := []struct {
+		games  [2]int
+		winner int
+	}{
+		{[2]int{6, 4}, 1},
+		{[2]int{3, 6}, 2},
+		{[2]int{7, 5}, 1},
+		{[2]int{6, 7}, 2},
+		{[2]int{6, 5}, 0},
+		{[2]int{6, 6}, 0},
+		{[2]int{4, 3}, 0},
+		{[2]int{8, 6}, 0},
+		{[2]int{7, 4}, 0},
+	}
+	for _, tt := range tests {
+		if got := DefaultFormat.SetWinner(tt.games[0], tt.games[1]); got != tt.winner {
+			t.Errorf("SetWinner(%d, %d) = %d, want %d", tt.games[0], tt.games[1], got, tt.winner)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	bestOf3 := Format{Sets: 3, GamesPerSet: 6, TieBreakPoints: 7}
+	tests := []struct {
+		name    string
+		format  Format
+		sets    [][2]int
+		setsWon [2]int
+		winner  int
+	}{
+		{"one set", DefaultFormat, [][2]int{{6, 4}}, [2]int{1, 0}, 1},
+		{"two sets", bestOf3, [][2]int{{4, 6}, {5, 7}}, [2]int{0, 2}, 2},
+		{"fewer games won", bestOf3, [][2]int{{7, 6}, {0, 6}, {7, 6}}, [2]int{2, 1}, 1},
+		{"unfinished set", DefaultFormat, [][2]int{{4, 3}}, [2]int{}, 0},
+		{"undecided", bestOf3, [][2]int{{6, 4}, {4, 6}}, [2]int{}, 0},
+		{"set after the end", bestOf3, [][2]int{{6, 0}, {6, 0}, {0, 6}}, [2]int{}, 0},
+		{"impossible set", bestOf3, [][2]int{{6, 0}, {9, 7}}, [2]int{}, 0},
+		{"no sets", DefaultFormat, nil, [2]int{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := tt.format.Decide(tt.sets)
+			if (err == nil) != (tt.winner != 0) {
+				t.Fatalf("Decide() error = %v, want winner %d", err, tt.winner)
+			}
+			if r.Winner != tt.winner || r.SetsWon != tt.setsWon {
+				t.Errorf("Decide() = winner %d, sets won %v, want %d, %v", r.Winner, r.SetsWon, tt.winner, tt.setsWon)
+			}
+		})
+	}
+}
+
+func TestPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		seq    string
+		points [2]string
+		games  [2]int
+	}{
+		{"love", DefaultFormat, "", [2]string{"0", "0"}, [2]int{0, 0}},
+		{"fifteen thirty", DefaultFormat, "122", [2]string{"15", "30"}, [2]int{0, 0}},
+		{"forty love", DefaultFormat, "111", [2]string{"40", "0"}, [2]int{0, 0}},
+		{"game", DefaultFormat, "1111", [2]string{"0", "0"}, [2]int{1, 0}},
+		{"deuce", DefaultFormat, "111222", [2]string{"40", "40"}, [2]int{0, 0}},
+		{"advantage", DefaultFormat, "1112221", [2]string{"AD", "40"}, [2]int{0, 0}},
+		{"advantage lost", DefaultFormat, "11122212", [2]string{"40", "40"}, [2]int{0, 0}},
+		{"advantage receiver", DefaultFormat, "111222122", [2]string{"40", "AD"}, [2]int{0, 0}},
+		{"game after deuce", DefaultFormat, "1112221222", [2]string{"0", "0"}, [2]int{0, 1}},
+		{"golden point deuce", goldenPointFormat, "111222", [2]string{"40", "40"}, [2]int{0, 0}},
+		{"golden point", goldenPointFormat, "1112222", [2]string{"0", "0"}, [2]int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMatch(t, tt.format)
+			play(t, m, tt.seq)
+			s := m.State()
+			if s.Points != tt.points {
+				t.Errorf("points = %v, want %v", s.Points, tt.points)
+			}
+			if g1, g2 := m.Games(); [2]int{g1, g2} != tt.games {
+				t.Errorf("games = %d-%d, want %v", g1, g2, tt.games)
+			}
+			if s.Played != len(strings.ReplaceAll(tt.seq, " ", "")) {
+				t.Errorf("points played = %d", s.Played)
+			}
+		})
+	}
+}
+
+func TestSets(t *testing.T) {
+	bestOf3 := Format{Sets: 3, GamesPerSet: 6, TieBreakPoints: 7}
+	tests := []struct {
+		name     string
+		format   Format
+		seq      string
+		sets     [][2]int
+		tieBreak bool
+		points   [2]string
+		winner   int
+	}{
+		{
+			name:   "six love",
+			format: DefaultFormat,
+			seq:    games("1", 6),
+			sets:   [][2]int{{6, 0}},
+			points: [2]string{"0", "0"},
+			winner: 1,
+		},
+		{
+			name:   "six five goes on",
+			format: DefaultFormat,
+			seq:    alternate(5) + games("1", 1),
+			sets:   [][2]int{{6, 5}},
+			points: [2]string{"0", "0"},
+		},
+		{
+			name:   "seven five",
+			format: DefaultFormat,
+			seq:    alternate(5) + games("1", 2),
+			sets:   [][2]int{{7, 5}},
+			points: [2]string{"0", "0"},
+			winner: 1,
+		},
+		{
+			name:     "tie-break",
+			format:   DefaultFormat,
+			seq:      alternate(6) + "1212",
+			sets:     [][2]int{{6, 6}},
+			tieBreak: true,
+			points:   [2]string{"2", "2"},
+		},
+		{
+			name:     "tie-break needs two points",
+			format:   DefaultFormat,
+			seq:      alternate(6) + "121212121212 1",
+			sets:     [][2]int{{6, 6}},
+			tieBreak: true,
+			points:   [2]string{"7", "6"},
+		},
+		{
+			name:   "tie-break won",
+			format: DefaultFormat,
+			seq:    alternate(6) + "121212121212 22",
+			sets:   [][2]int{{6, 7}},
+			points: [2]string{"0", "0"},
+			winner: 2,
+		},
+		{
+			name:   "second set",
+			format: bestOf3,
+			seq:    games("1", 6) + games("2", 1),
+			sets:   [][2]int{{6, 0}, {0, 1}},
+			points: [2]string{"0", "0"},
+		},
+		{
+			name:   "third set",
+			format: bestOf3,
+			seq:    games("1", 6) + games("2", 6) + games("1", 6),
+			sets:   [][2]int{{6, 0}, {0, 6}, {6, 0}},
+			points: [2]string{"0", "0"},
+			winner: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMatch(t, tt.format)
+			play(t, m, tt.seq)
+			s := m.State()
+			if !reflect.DeepEqual(s.Sets, tt.sets) {
+				t.Errorf("sets = %v, want %v", s.Sets, tt.sets)
+			}
+			if s.TieBreak != tt.tieBreak {
+				t.Errorf("tie-break = %v, want %v", s.TieBreak, tt.tieBreak)
+			}
+			if s.Points != tt.points {
+				t.Errorf("points = %v, want %v", s.Points, tt.points)
+			}
+			if s.Finished != (tt.winner != 0) || s.Winner != tt.winner {
+				t.Errorf("finished = %v, winner = %d, want winner %d", s.Finished, s.Winner, tt.winner)
+			}
+		})
+	}
+}
+
+func TestFinished(t *testing.T) {
+	m := newMatch(t, DefaultFormat)
+	play(t, m, games("2", 6))
+	if err := m.PointWon(1); err == nil {
+		t.Error("point recorded after the end of the match")
+	}
+	if err := m.PointWon(3); err == nil {
+		t.Error("point recorded for team 3")
+	}
+	if g1, g2 := m.Games(); g1 != 0 || g2 != 6 {
+		t.Errorf("games = %d-%d, want 0-6", g1, g2)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name string
+		seq  string
+	}{
+		{"within a game", "12"},
+		{"game", "111"},
+		{"advantage", "111222"},
+		{"set", alternate(5) + games("1", 1) + "111"},
+		{"tie-break", alternate(6)},
+		{"match", games("1", 5) + "111"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := newMatch(t, DefaultFormat)
+			play(t, want, tt.seq)
+
+			m := newMatch(t, DefaultFormat)
+			play(t, m, tt.seq+"1")
+			if err := m.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.State(); !reflect.DeepEqual(got, want.State()) {
+				t.Errorf("state after undo = %+v, want %+v", got, want.State())
+			}
+			if err := m.PointWon(1); err != nil {
+				t.Errorf("point after undo: %v", err)
+			}
+		})
+	}
+
+	m := newMatch(t, DefaultFormat)
+	if err := m.Undo(); err == nil {
+		t.Error("undo without points succeeded")
+	}
+}
+
+func TestStateIsACopy(t *testing.T) {
+	m := newMatch(t, DefaultFormat)
+	play(t, m, "1111")
+	s := m.State()
+	s.Sets[0][0] = 5
+	if m.State().Sets[0][0] != 1 {
+		t.Error("changing the returned state changed the match")
+	}
+}
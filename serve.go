@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/p4u/padelfriends/bot"
+	"github.com/p4u/padelfriends/config"
+	"github.com/p4u/padelfriends/db"
+	"github.com/p4u/padelfriends/events"
+	"github.com/p4u/padelfriends/handlers"
+	"github.com/p4u/padelfriends/lifecycle"
+	"github.com/p4u/padelfriends/live"
+	"github.com/p4u/padelfriends/logging"
+	"github.com/p4u/padelfriends/metrics"
+	"github.com/p4u/padelfriends/migrations"
+	"github.com/p4u/padelfriends/notify"
+	"github.com/p4u/padelfriends/openapi"
+	"github.com/p4u/padelfriends/ratelimit"
+	"github.com/p4u/padelfriends/router"
+	"github.com/p4u/padelfriends/services"
+	"github.com/p4u/padelfriends/ui"
+	"github.com/p4u/padelfriends/webhooks"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// serve runs the server until it is interrupted.
+func serve(cfg *config.Config) {
+	started := time.Now()
+
+	// Requests in a separate access log
+	var accessLog *slog.Logger
+	accessOut, err := logging.OpenLog(cfg.Log.AccessLog)
+	if err != nil {
+		fatal("Failed to open the access log", err)
+	}
+	if accessOut != nil {
+		accessLog, _ = logging.NewLogger(accessOut, cfg.Log.Format, slog.LevelInfo)
+	}
+
+	// Security events, such as the lockouts, in the audit log
+	var auditLog *slog.Logger
+	auditOut, err := logging.OpenLog(cfg.Log.AuditLog)
+	if err != nil {
+		fatal("Failed to open the audit log", err)
+	}
+	if auditOut != nil {
+		auditLog, _ = logging.NewLogger(auditOut, cfg.Log.Format, slog.LevelInfo)
+	}
+
+	// Limits of the password guesses, shared by the API and the chat bot
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter = ratelimit.New(ratelimit.Options{
+			ClientPerMinute: cfg.RateLimit.ClientPerMinute,
+			ClientBurst:     cfg.RateLimit.ClientBurst,
+			GroupPerMinute:  cfg.RateLimit.GroupPerMinute,
+			GroupBurst:      cfg.RateLimit.GroupBurst,
+			MaxFailures:     cfg.RateLimit.MaxFailures,
+			FailureWindow:   cfg.RateLimit.FailureWindow,
+			Lockout:         cfg.RateLimit.Lockout,
+			MaxLockout:      cfg.RateLimit.MaxLockout,
+		}, ratelimit.NewMemory(), auditLog)
+	}
+
+	// Prometheus metrics, observing the MongoDB commands from the start
+	var monitor *event.CommandMonitor
+	var prom *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		prom = metrics.New()
+		monitor = prom.CommandMonitor()
+	}
+
+	// Connect to MongoDB
+	mdb, err := db.Connect(cfg.MongoDB, monitor)
+	if err != nil {
+		fatal("Failed to connect to MongoDB", err)
+	}
+
+	// Components started in order once built, and stopped in reverse order:
+	// the servers first, the workers next and the database client last
+	app := lifecycle.New(cfg.Server.StopTimeout)
+	app.Append(lifecycle.Hook{Name: "mongodb", Stop: mdb.Client.Disconnect})
+
+	// Event bus shared by the services and the live update streams
+	bus := events.NewBus(events.DefaultHistorySize)
+
+	// Initialize services
+	// Webhook deliveries are only queued while they are delivered
+	webhookService := services.NewWebhookService(mdb.Database, cfg.Webhooks.AllowPrivate)
+	var webhookQueue *services.WebhookService
+	if cfg.Features.Webhooks {
+		webhookQueue = webhookService
+	}
+
+	groupService := services.NewGroupService(mdb.Database)
+	playerService := services.NewPlayerService(mdb.Database, bus, webhookQueue)
+	matchService := services.NewMatchService(mdb.Database, bus, webhookQueue)
+	statsService := services.NewStatsService(mdb.Database)
+	identityService := services.NewIdentityService(mdb.Database)
+	teamService := services.NewTeamService(mdb.Database)
+	botService := services.NewBotService(mdb.Database)
+
+	// Indexes and documents of older versions. The server still runs when a
+	// migration fails, without the guarantees of the later ones.
+	migrator := migrations.New(mdb.Database)
+	if cfg.MongoDB.Migrate {
+		results, err := migrator.Up(context.Background(), false)
+		for _, res := range results {
+			slog.Info("Applied migration", "version", res.Version, "description", res.Description, "report", res.Report)
+		}
+		if err != nil {
+			slog.Error("Failed to migrate the database", "error", err)
+		}
+	} else if pending, err := migrator.Pending(context.Background()); err != nil {
+		slog.Error("Failed to check the database migrations", "error", err)
+	} else if len(pending) > 0 {
+		slog.Warn("Database migrations are pending, apply them with the migrate command", "pending", len(pending))
+	}
+
+	avatarStore, err := services.NewAvatarStore(cfg.AvatarDir)
+	if err != nil {
+		fatal("Failed to initialize avatar store", err)
+	}
+
+	// Initialize handlers
+	groupHandler := &handlers.GroupHandler{GroupService: groupService}
+	playerHandler := &handlers.PlayerHandler{GroupService: groupService, PlayerService: playerService, AvatarStore: avatarStore}
+	matchHandler := &handlers.MatchHandler{GroupService: groupService, MatchService: matchService}
+	statsHandler := &handlers.StatsHandler{GroupService: groupService, StatsService: statsService}
+	identityHandler := &handlers.IdentityHandler{
+		GroupService:    groupService,
+		IdentityService: identityService,
+		MatchService:    matchService,
+		StatsService:    statsService,
+	}
+
+	teamHandler := &handlers.TeamHandler{GroupService: groupService, TeamService: teamService}
+	eventsHandler := &handlers.EventsHandler{Bus: bus}
+
+	// Live scoring sessions, closed when their match changes elsewhere
+	liveHub := live.NewHub(matchService)
+	app.Go("live", func(ctx context.Context) { liveHub.Run(ctx, bus) })
+	liveHandler := &handlers.LiveHandler{GroupService: groupService, MatchService: matchService, Hub: liveHub}
+
+	// Webhook deliveries from the persistent queue
+	if cfg.Features.Webhooks {
+		opts := webhooks.DefaultOptions
+		opts.AllowPrivate = cfg.Webhooks.AllowPrivate
+		dispatcher := webhooks.NewDispatcher(webhookService, opts)
+		app.Go("webhooks", func(ctx context.Context) { dispatcher.Run(ctx) })
+	}
+	webhookHandler := &handlers.WebhookHandler{GroupService: groupService, WebhookService: webhookService}
+
+	// Email notifications, when an SMTP server is configured
+	if cfg.Features.Notifications && cfg.SMTP.Enabled() {
+		templates, err := notify.LoadTemplates(cfg.SMTP.TemplateDir)
+		if err != nil {
+			fatal("Failed to load notification templates", err)
+		}
+		notifier := &notify.Notifier{
+			Mailer:     notify.NewSMTPMailer(cfg.SMTP),
+			Templates:  templates,
+			Groups:     groupService,
+			Players:    playerService,
+			Matches:    matchService,
+			Stats:      statsService,
+			DigestDay:  cfg.SMTP.DigestDay,
+			DigestHour: cfg.SMTP.DigestHour,
+		}
+		app.Go("notifications", func(ctx context.Context) { notifier.Run(ctx, bus) })
+	}
+
+	// Chat bot, for each configured transport
+	botHandler := &handlers.BotHandler{GroupService: groupService, BotService: botService}
+	healthHandler := &handlers.HealthHandler{
+		Client:   mdb.Client,
+		Migrator: migrator,
+		Started:  started,
+		Token:    cfg.Server.HealthToken,
+	}
+	if cfg.Features.Bot && cfg.Bot.Enabled() {
+		chatBot := &bot.Bot{
+			Links:   botService,
+			Groups:  groupService,
+			Players: playerService,
+			Matches: matchService,
+			Stats:   statsService,
+			Limiter: limiter,
+		}
+		if cfg.Bot.TelegramToken != "" {
+			chatBot.AddTransport(bot.NewTelegram(cfg.Bot.TelegramToken, cfg.Bot.TelegramAPIURL))
+		}
+		if cfg.Bot.MatrixHomeserver != "" {
+			chatBot.AddTransport(bot.NewMatrix(cfg.Bot.MatrixHomeserver, cfg.Bot.MatrixToken))
+		}
+		app.Go("bot", chatBot.Run)
+	}
+
+	middlewares := []func(http.Handler) http.Handler{handlers.SecurityHeaders(cfg.Static.HSTSMaxAge)}
+	if len(cfg.Server.CORSOrigins) > 0 {
+		middlewares = append(middlewares, handlers.CORS(cfg.Server.CORSOrigins))
+	}
+	if prom != nil {
+		prom.CountStore(mdb.Database)
+		app.Go("metrics", func(ctx context.Context) { prom.Run(ctx, bus) })
+		middlewares = append(middlewares, prom.Middleware)
+	}
+
+	// Contract checks of the responses against the OpenAPI document
+	var validator *openapi.Validator
+	if cfg.Features.OpenAPIValidate {
+		doc, err := openapi.Load()
+		if err != nil {
+			fatal("Failed to load the OpenAPI document", err)
+		}
+		validator = openapi.NewValidator(doc)
+		middlewares = append(middlewares, validator.Middleware)
+	}
+
+	// Web application, embedded when built with the embedui tag
+	webapp := ui.Dist()
+	staticOpts := handlers.StaticOptions{ContentSecurityPolicy: cfg.Static.ContentSecurityPolicy}
+	if cfg.Static.Dir != "" || !ui.Embedded {
+		dir := cfg.Static.Dir
+		if dir == "" {
+			dir = handlers.DefaultWebAppDir
+		}
+		if webapp, err = handlers.DirFS(dir); err != nil {
+			fatal("Failed to open the web application directory", err)
+		}
+		staticOpts.Live = cfg.Static.Live
+		slog.Info("Serving the web application from a directory", "dir", dir, "live", staticOpts.Live)
+	}
+	staticHandler, err := handlers.NewStaticHandler(webapp, staticOpts)
+	if err != nil {
+		fatal("Failed to load the web application", err)
+	}
+
+	// Throttle the password guesses over HTTP
+	var rateLimit func(http.Handler) http.Handler
+	if limiter != nil {
+		rateLimit = handlers.RateLimit(limiter, cfg.RateLimit.TrustProxy)
+	}
+
+	// Create router
+	r := router.New(groupHandler, playerHandler, matchHandler, statsHandler, identityHandler, teamHandler,
+		eventsHandler, liveHandler, webhookHandler, botHandler, healthHandler, staticHandler, accessLog, rateLimit,
+		middlewares...)
+	if validator != nil {
+		for _, problem := range validator.CheckRoutes(r) {
+			slog.Warn("openapi: route mismatch", "problem", problem)
+		}
+	}
+
+	// Live scores are lost on restart, their clients are told once the API
+	// server no longer accepts new ones
+	app.Append(lifecycle.Hook{Name: "live sessions", Stop: func(context.Context) error {
+		liveHub.Shutdown()
+		return nil
+	}})
+
+	// Serving errors after the startup stop the application
+	failed := make(chan error, 2)
+
+	// Metrics, on their own listener, kept apart from the public API
+	if prom != nil {
+		admin := http.NewServeMux()
+		admin.Handle("/metrics", prom.Handler())
+		adminSrv := &http.Server{Addr: cfg.Metrics.Addr, Handler: admin, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout}
+		app.Append(serverHook("metrics server", adminSrv, "", "", failed))
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(eventsHandler.Shutdown)
+	hook := serverHook("api server", srv, cfg.Server.TLSCert, cfg.Server.TLSKey, failed)
+	hook.Timeout = cfg.Server.ShutdownTimeout
+	app.Append(hook)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	// Start server
+	if err := app.Start(context.Background()); err != nil {
+		fatal("Failed to start", err)
+	}
+
+	exitCode := 0
+	select {
+	case <-stop:
+		// Keep serving while the load balancers notice the failing
+		// readiness, unless interrupted again
+		healthHandler.Drain()
+		if cfg.Server.DrainDelay > 0 {
+			slog.Info("Draining connections", "delay", cfg.Server.DrainDelay)
+			select {
+			case <-time.After(cfg.Server.DrainDelay):
+			case <-stop:
+			}
+		}
+	case err := <-failed:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+	slog.Info("Shutting down server")
+
+	if err := app.Stop(); err != nil {
+		// One error per component that failed to drain
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			slog.Error("Failed to stop", "error", err)
+		}
+		os.Exit(1)
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+	slog.Info("Server exited properly")
+}
+
+// serverHook runs an HTTP server, serving TLS when certFile is set. It
+// listens on start, so that an unavailable address fails the startup, and
+// reports the later serving errors to failed.
+func serverHook(name string, srv *http.Server, certFile, keyFile string, failed chan<- error) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			slog.Info("Starting server", "server", name, "addr", ln.Addr().String(), "tls", certFile != "")
+			go func() {
+				var err error
+				if certFile != "" {
+					err = srv.ServeTLS(ln, certFile, keyFile)
+				} else {
+					err = srv.Serve(ln)
+				}
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					failed <- fmt.Errorf("%s: %w", name, err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return err
+			}
+			return nil
+		},
+	}
+}
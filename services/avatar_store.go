@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxAvatarSize is the largest accepted avatar image, in bytes.
+const MaxAvatarSize = 2 << 20
+
+// avatarExtensions maps the accepted image content types to file extensions.
+var avatarExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// AvatarStore keeps player avatar images in a local directory.
+type AvatarStore struct {
+	dir string
+}
+
+// NewAvatarStore creates an AvatarStore writing into dir, creating it if needed.
+func NewAvatarStore(dir string) (*AvatarStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create avatar directory: %w", err)
+	}
+	return &AvatarStore{dir: dir}, nil
+}
+
+// Save stores the image of a player and returns its file name. The content
+// type is sniffed from the data; only common image formats are accepted.
+func (s *AvatarStore) Save(playerID primitive.ObjectID, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", invalid("avatar", "empty avatar image")
+	}
+	if len(data) > MaxAvatarSize {
+		return "", invalid("avatar", "avatar image too large")
+	}
+
+	ext, ok := avatarExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", invalid("avatar", "unsupported avatar image format")
+	}
+
+	name := playerID.Hex() + ext
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Path returns the location of a stored avatar file.
+func (s *AvatarStore) Path(name string) string {
+	return filepath.Join(s.dir, filepath.Base(name))
+}
+
+// Remove deletes a stored avatar file, ignoring missing files.
+func (s *AvatarStore) Remove(name string) error {
+	if name == "" {
+		return nil
+	}
+	if err := os.Remove(s.Path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BotLinkTokenTTL is the validity of the chat linking tokens.
+const BotLinkTokenTTL = time.Hour
+
+// BotService stores which chat of which bot transport belongs to which group.
+type BotService struct {
+	db *mongo.Database
+}
+
+// NewBotService creates a new BotService.
+func NewBotService(db *mongo.Database) *BotService {
+	return &BotService{db: db}
+}
+
+// CreateLinkToken returns a single-use token that links a chat to the group
+// when sent to the bot. Only its hash is stored.
+func (s *BotService) CreateLinkToken(ctx context.Context, groupName string) (string, time.Time, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(raw)
+	expires := time.Now().Add(BotLinkTokenTTL)
+
+	_, err := s.db.Collection("bot_link_tokens").InsertOne(ctx, bson.M{
+		"token_hash": hashToken(token),
+		"group_name": groupName,
+		"expires_at": expires,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// LinkChatWithToken consumes a linking token and links the chat to its group.
+func (s *BotService) LinkChatWithToken(ctx context.Context, transport, chatID, token string) (string, error) {
+	var t struct {
+		GroupName string    `bson:"group_name"`
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	err := s.db.Collection("bot_link_tokens").FindOneAndDelete(ctx, bson.M{
+		"token_hash": hashToken(token),
+	}).Decode(&t)
+	if err == mongo.ErrNoDocuments || (err == nil && time.Now().After(t.ExpiresAt)) {
+		return "", invalid("token", "invalid or expired link token")
+	}
+	if err != nil {
+		return "", err
+	}
+	return t.GroupName, s.LinkChat(ctx, transport, chatID, t.GroupName)
+}
+
+// LinkChat links a chat to a group, replacing any previous link of the chat.
+func (s *BotService) LinkChat(ctx context.Context, transport, chatID, groupName string) error {
+	_, err := s.db.Collection("bot_links").UpdateOne(ctx,
+		bson.M{"transport": transport, "chat_id": chatID},
+		bson.M{"$set": models.BotLink{
+			Transport: transport,
+			ChatID:    chatID,
+			GroupName: groupName,
+			LinkedAt:  time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// UnlinkChat removes the link of a chat.
+func (s *BotService) UnlinkChat(ctx context.Context, transport, chatID string) error {
+	_, err := s.db.Collection("bot_links").DeleteOne(ctx, bson.M{"transport": transport, "chat_id": chatID})
+	return err
+}
+
+// ChatGroup returns the group linked to a chat, or an empty string.
+func (s *BotService) ChatGroup(ctx context.Context, transport, chatID string) (string, error) {
+	var link models.BotLink
+	err := s.db.Collection("bot_links").FindOne(ctx, bson.M{"transport": transport, "chat_id": chatID}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return link.GroupName, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Kinds of service errors, to be tested with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrForbidden    = errors.New("forbidden")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Error is an error of a known kind with a message safe to show to clients.
+// Field names the invalid input of validation errors.
+type Error struct {
+	Kind    error
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind of the error.
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func notFound(message string) error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+func conflict(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+func invalid(field, message string) error {
+	return &Error{Kind: ErrValidation, Field: field, Message: message}
+}
+
+func forbidden(message string) error {
+	return &Error{Kind: ErrForbidden, Message: message}
+}
+
+func unauthorized(message string) error {
+	return &Error{Kind: ErrUnauthorized, Message: message}
+}
+
+// notFoundIf converts mongo.ErrNoDocuments into a not found error with the
+// given message and returns other errors unchanged.
+func notFoundIf(err error, message string) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFound(message)
+	}
+	return err
+}
+
+// conflictIfDuplicate converts the duplicate key errors of the unique
+// indexes, raised when concurrent requests both passed the checks, into a
+// conflict error with the given message and returns other errors unchanged.
+func conflictIfDuplicate(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return conflict(message)
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// groupCollections are the collections whose documents belong to a group
+// through their group_name field.
+var groupCollections = []string{
+	"players",
+	"matches",
+	"webhooks",
+	"webhook_deliveries",
+	"bot_links",
+	"bot_link_tokens",
+	"idempotency_keys",
+}
+
+// GroupDeletion summarizes what deleting a group removed.
+type GroupDeletion struct {
+	Players int64 `json:"players"`
+	Matches int64 `json:"matches"`
+
+	// Avatars are the stored avatar files of the deleted players, for the
+	// caller to remove from the avatar store.
+	Avatars []string `json:"-"`
+}
+
+// DeleteGroup removes a group with its players, matches, webhooks and chat
+// links, all within a single transaction.
+func (s *GroupService) DeleteGroup(ctx context.Context, name string) (GroupDeletion, error) {
+	if _, err := s.GetGroupByName(ctx, name); err != nil {
+		return GroupDeletion{}, err
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return GroupDeletion{}, err
+	}
+	defer session.EndSession(ctx)
+
+	deleted, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		var result GroupDeletion
+		filter := bson.M{"group_name": name}
+
+		var players []models.Player
+		cur, err := s.db.Collection("players").Find(sessCtx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err := cur.All(sessCtx, &players); err != nil {
+			return nil, err
+		}
+		for _, p := range players {
+			if p.Avatar != "" {
+				result.Avatars = append(result.Avatars, p.Avatar)
+			}
+		}
+
+		matchIDs, err := s.groupMatchIDs(sessCtx, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.db.Collection("matchdetails").DeleteMany(sessCtx, bson.M{"match_id": bson.M{"$in": matchIDs}}); err != nil {
+			return nil, err
+		}
+
+		for _, coll := range groupCollections {
+			res, err := s.db.Collection(coll).DeleteMany(sessCtx, filter)
+			if err != nil {
+				return nil, err
+			}
+			switch coll {
+			case "players":
+				result.Players = res.DeletedCount
+			case "matches":
+				result.Matches = res.DeletedCount
+			}
+		}
+
+		if _, err := s.db.Collection("groups").DeleteOne(sessCtx, bson.M{"name": name}); err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	if err != nil {
+		return GroupDeletion{}, err
+	}
+	return deleted.(GroupDeletion), nil
+}
+
+// SetPassword replaces the password of a group.
+func (s *GroupService) SetPassword(ctx context.Context, name, password string) error {
+	if password == "" {
+		return invalid("password", "missing password")
+	}
+	hash, err := models.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Collection("groups").UpdateOne(ctx,
+		bson.M{"name": name},
+		bson.M{"$set": bson.M{"password_hash": hash}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return notFound("group not found")
+	}
+	return nil
+}
+
+// RenameGroup changes the name of a group in every document referencing it,
+// within a single transaction. The slug is kept, so that the links to the
+// group keep working. Pending idempotency keys of the group are dropped.
+func (s *GroupService) RenameGroup(ctx context.Context, name, newName string) error {
+	if newName == "" {
+		return invalid("name", "missing name")
+	}
+	if newName == name {
+		return nil
+	}
+	if _, err := s.GetGroupByName(ctx, name); err != nil {
+		return err
+	}
+	count, err := s.db.Collection("groups").CountDocuments(ctx, bson.M{"name": newName})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return conflict(fmt.Sprintf("group name '%s' already exists", newName))
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{"group_name": name}
+		rename := bson.M{"$set": bson.M{"group_name": newName}}
+		for _, coll := range groupCollections {
+			var err error
+			if coll == "idempotency_keys" {
+				// Their IDs embed the group name
+				_, err = s.db.Collection(coll).DeleteMany(sessCtx, filter)
+			} else {
+				_, err = s.db.Collection(coll).UpdateMany(sessCtx, filter, rename)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err := s.db.Collection("groups").UpdateOne(sessCtx,
+			bson.M{"name": name},
+			bson.M{"$set": bson.M{"name": newName}},
+		)
+		return nil, err
+	})
+	return conflictIfDuplicate(err, fmt.Sprintf("group name '%s' already exists", newName))
+}
+
+// groupMatchIDs returns the IDs of every match of a group.
+func (s *GroupService) groupMatchIDs(ctx context.Context, name string) ([]primitive.ObjectID, error) {
+	ids, err := s.db.Collection("matches").Distinct(ctx, "_id", bson.M{"group_name": name})
+	if err != nil {
+		return nil, err
+	}
+	matchIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			matchIDs = append(matchIDs, oid)
+		}
+	}
+	return matchIDs, nil
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ArchiveVersion is the version of the group archive format.
+const ArchiveVersion = 1
+
+// GroupArchive is a self-contained copy of a group, its players and its
+// matches, for moving a group between servers.
+type GroupArchive struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Group      ArchivedGroup   `json:"group"`
+	Players    []models.Player `json:"players"`
+	Matches    []ArchivedMatch `json:"matches"`
+}
+
+// ArchivedGroup is a group with its password hash, so that the members can
+// still log in after an import.
+type ArchivedGroup struct {
+	Name         string               `json:"name"`
+	Slug         string               `json:"slug"`
+	PasswordHash string               `json:"password_hash"`
+	CreatedAt    time.Time            `json:"created_at"`
+	Settings     models.GroupSettings `json:"settings"`
+}
+
+// ArchivedMatch is a match with its teams and score.
+type ArchivedMatch struct {
+	models.Match
+	Team1      []primitive.ObjectID `json:"team1"`
+	Team2      []primitive.ObjectID `json:"team2"`
+	ScoreTeam1 int                  `json:"score_team1"`
+	ScoreTeam2 int                  `json:"score_team2"`
+	Sets       [][2]int             `json:"sets,omitempty"`
+}
+
+// ExportGroup returns the archive of a group, including its deactivated
+// players and its cancelled matches.
+func (s *GroupService) ExportGroup(ctx context.Context, name string) (GroupArchive, error) {
+	g, err := s.GetGroupByName(ctx, name)
+	if err != nil {
+		return GroupArchive{}, err
+	}
+	archive := GroupArchive{
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Group: ArchivedGroup{
+			Name:         g.Name,
+			Slug:         g.Slug,
+			PasswordHash: g.PasswordHash,
+			CreatedAt:    g.CreatedAt,
+			Settings:     g.Settings,
+		},
+		Players: []models.Player{},
+		Matches: []ArchivedMatch{},
+	}
+
+	cur, err := s.db.Collection("players").Find(ctx, bson.M{"group_name": name},
+		options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return GroupArchive{}, err
+	}
+	if err := cur.All(ctx, &archive.Players); err != nil {
+		return GroupArchive{}, err
+	}
+
+	var matches []models.Match
+	cur, err = s.db.Collection("matches").Find(ctx, bson.M{"group_name": name},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return GroupArchive{}, err
+	}
+	if err := cur.All(ctx, &matches); err != nil {
+		return GroupArchive{}, err
+	}
+
+	ids := make([]primitive.ObjectID, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	var details []models.MatchDetail
+	cur, err = s.db.Collection("matchdetails").Find(ctx, bson.M{"match_id": bson.M{"$in": ids}})
+	if err != nil {
+		return GroupArchive{}, err
+	}
+	if err := cur.All(ctx, &details); err != nil {
+		return GroupArchive{}, err
+	}
+	byMatch := make(map[primitive.ObjectID]models.MatchDetail, len(details))
+	for _, d := range details {
+		byMatch[d.MatchID] = d
+	}
+
+	for _, m := range matches {
+		d, ok := byMatch[m.ID]
+		if !ok {
+			continue
+		}
+		archive.Matches = append(archive.Matches, ArchivedMatch{
+			Match:      m,
+			Team1:      d.Team1,
+			Team2:      d.Team2,
+			ScoreTeam1: d.ScoreTeam1,
+			ScoreTeam2: d.ScoreTeam2,
+			Sets:       d.Sets,
+		})
+	}
+	return archive, nil
+}
+
+// ImportGroup creates a group from an archive, under the given name or the
+// archived one when empty, within a single transaction. Players and matches
+// get new IDs; avatars and identity links are not part of archives.
+func (s *GroupService) ImportGroup(ctx context.Context, archive GroupArchive, name string) (models.Group, error) {
+	if archive.Version != ArchiveVersion {
+		return models.Group{}, invalid("version", fmt.Sprintf("unsupported archive version %d", archive.Version))
+	}
+	if name == "" {
+		name = archive.Group.Name
+	}
+	if name == "" {
+		return models.Group{}, invalid("name", "missing name")
+	}
+	if archive.Group.PasswordHash == "" {
+		return models.Group{}, invalid("password_hash", "missing password hash")
+	}
+
+	count, err := s.db.Collection("groups").CountDocuments(ctx, bson.M{"name": name})
+	if err != nil {
+		return models.Group{}, err
+	}
+	if count > 0 {
+		return models.Group{}, conflict(fmt.Sprintf("group name '%s' already exists", name))
+	}
+
+	// Keep the archived slug when it is free, so that shared links still work
+	slug := archive.Group.Slug
+	if slug == "" {
+		slug = Slugify(name)
+	}
+	if slug, err = s.uniqueSlug(ctx, slug); err != nil {
+		return models.Group{}, err
+	}
+
+	group := models.Group{
+		Name:         name,
+		Slug:         slug,
+		PasswordHash: archive.Group.PasswordHash,
+		CreatedAt:    archive.Group.CreatedAt,
+		Settings:     archive.Group.Settings,
+	}
+	if group.CreatedAt.IsZero() {
+		group.CreatedAt = time.Now()
+	}
+
+	playerIDs := make(map[primitive.ObjectID]primitive.ObjectID, len(archive.Players))
+	players := make([]interface{}, len(archive.Players))
+	for i, p := range archive.Players {
+		if _, dup := playerIDs[p.ID]; dup || p.ID.IsZero() {
+			return models.Group{}, invalid(fmt.Sprintf("players[%d].id", i), "missing or duplicate player ID")
+		}
+		playerIDs[p.ID] = primitive.NewObjectID()
+		p.ID = playerIDs[p.ID]
+		p.GroupName = name
+		p.Avatar = ""
+		p.IdentityID = nil
+		players[i] = p
+	}
+
+	matches := make([]interface{}, len(archive.Matches))
+	details := make([]interface{}, len(archive.Matches))
+	for i, m := range archive.Matches {
+		if len(m.Team1) != 2 || len(m.Team2) != 2 {
+			return models.Group{}, invalid(fmt.Sprintf("matches[%d]", i), "teams must have 2 players")
+		}
+		detail := models.MatchDetail{
+			MatchID:    primitive.NewObjectID(),
+			ScoreTeam1: m.ScoreTeam1,
+			ScoreTeam2: m.ScoreTeam2,
+			Sets:       m.Sets,
+		}
+		for j, id := range append(append([]primitive.ObjectID{}, m.Team1...), m.Team2...) {
+			newID, ok := playerIDs[id]
+			if !ok {
+				return models.Group{}, invalid(fmt.Sprintf("matches[%d]", i), "unknown player "+id.Hex())
+			}
+			if j < 2 {
+				detail.Team1 = append(detail.Team1, newID)
+			} else {
+				detail.Team2 = append(detail.Team2, newID)
+			}
+		}
+		match := m.Match
+		match.ID = detail.MatchID
+		match.GroupName = name
+		if match.Version == 0 {
+			match.Version = 1
+		}
+		matches[i] = match
+		details[i] = detail
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return models.Group{}, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if _, err := s.db.Collection("groups").InsertOne(sessCtx, group); err != nil {
+			return nil, err
+		}
+		for coll, docs := range map[string][]interface{}{
+			"players":      players,
+			"matches":      matches,
+			"matchdetails": details,
+		} {
+			if len(docs) == 0 {
+				continue
+			}
+			if _, err := s.db.Collection(coll).InsertMany(sessCtx, docs); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return models.Group{}, conflictIfDuplicate(err, fmt.Sprintf("group name '%s' already exists", name))
+	}
+	return group, nil
+}
@@ -3,7 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -27,13 +27,20 @@ func NewGroupService(db *mongo.Database) *GroupService {
 func (s *GroupService) CreateGroup(ctx context.Context, name, password string) (models.Group, error) {
 	groupsColl := s.db.Collection("groups")
 
+	if name == "" {
+		return models.Group{}, invalid("name", "missing name")
+	}
+	if password == "" {
+		return models.Group{}, invalid("password", "missing password")
+	}
+
 	// Check if a group with the same name already exists
 	count, err := groupsColl.CountDocuments(ctx, bson.M{"name": name})
 	if err != nil {
 		return models.Group{}, err
 	}
 	if count > 0 {
-		return models.Group{}, fmt.Errorf("duplicate key: group name '%s' already exists", name)
+		return models.Group{}, conflict(fmt.Sprintf("group name '%s' already exists", name))
 	}
 
 	// Hash the password
@@ -42,15 +49,21 @@ func (s *GroupService) CreateGroup(ctx context.Context, name, password string) (
 		return models.Group{}, err
 	}
 
+	slug, err := s.uniqueSlug(ctx, Slugify(name))
+	if err != nil {
+		return models.Group{}, err
+	}
+
 	group := models.Group{
 		Name:         name,
+		Slug:         slug,
 		PasswordHash: hash,
 		CreatedAt:    time.Now(),
 	}
 
 	_, err = groupsColl.InsertOne(ctx, group)
 	if err != nil {
-		return models.Group{}, err
+		return models.Group{}, conflictIfDuplicate(err, fmt.Sprintf("group name '%s' already exists", name))
 	}
 
 	return group, nil
@@ -62,13 +75,119 @@ func (s *GroupService) GetGroupByName(ctx context.Context, name string) (models.
 	var g models.Group
 	err := groupsColl.FindOne(ctx, bson.M{"name": name}).Decode(&g)
 	if err != nil {
-		return models.Group{}, err
+		return models.Group{}, notFoundIf(err, "group not found")
 	}
 	return g, nil
 }
 
+// GetGroupBySlug retrieves a group by its slug.
+func (s *GroupService) GetGroupBySlug(ctx context.Context, slug string) (models.Group, error) {
+	var g models.Group
+	err := s.db.Collection("groups").FindOne(ctx, bson.M{"slug": slug}).Decode(&g)
+	if err != nil {
+		return models.Group{}, notFoundIf(err, "group not found")
+	}
+	return g, nil
+}
+
+// BackfillSlugs assigns a slug to the groups created before slugs existed and
+// returns how many were updated.
+func (s *GroupService) BackfillSlugs(ctx context.Context) (int, error) {
+	groupsColl := s.db.Collection("groups")
+
+	cur, err := groupsColl.Find(ctx, bson.M{"slug": bson.M{"$in": bson.A{nil, ""}}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var groups []models.Group
+	if err := cur.All(ctx, &groups); err != nil {
+		return 0, err
+	}
+
+	for i, g := range groups {
+		slug, err := s.uniqueSlug(ctx, Slugify(g.Name))
+		if err != nil {
+			return i, err
+		}
+		if _, err := groupsColl.UpdateOne(ctx, bson.M{"name": g.Name}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+			return i, err
+		}
+	}
+	return len(groups), nil
+}
+
+// uniqueSlug returns base, or base followed by the first free number suffix.
+func (s *GroupService) uniqueSlug(ctx context.Context, base string) (string, error) {
+	slug := base
+	for n := 2; ; n++ {
+		count, err := s.db.Collection("groups").CountDocuments(ctx, bson.M{"slug": slug})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// slugReplacer folds the accented letters common in group names.
+var slugReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "l·l", "ll",
+)
+
+// Slugify converts a group name to a lowercase identifier made of ASCII
+// letters, digits and dashes.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range slugReplacer.Replace(strings.ToLower(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		return "group"
+	}
+	return slug
+}
+
+// UpdateSettings replaces the settings of a group.
+func (s *GroupService) UpdateSettings(ctx context.Context, name string, settings models.GroupSettings) (models.GroupSettings, error) {
+	switch settings.GuestStats {
+	case "", models.GuestStatsExclude, models.GuestStatsMark:
+	default:
+		return models.GroupSettings{}, invalid("guest_stats", fmt.Sprintf("invalid guest_stats mode '%s'", settings.GuestStats))
+	}
+	settings.GuestStats = settings.GuestStatsMode()
+
+	res, err := s.db.Collection("groups").UpdateOne(ctx,
+		bson.M{"name": name},
+		bson.M{"$set": bson.M{"settings": settings}},
+	)
+	if err != nil {
+		return models.GroupSettings{}, err
+	}
+	if res.MatchedCount == 0 {
+		return models.GroupSettings{}, notFound("group not found")
+	}
+	return settings, nil
+}
+
 type GroupDetails struct {
 	Name      string    `bson:"name" json:"name"`
+	Slug      string    `bson:"slug" json:"slug"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
@@ -80,6 +199,7 @@ func (s *GroupService) ListGroups(ctx context.Context) ([]*GroupDetails, error)
 		SetSort(bson.D{{Key: "name", Value: 1}}).
 		SetProjection(bson.D{
 			{Key: "name", Value: 1},
+			{Key: "slug", Value: 1},
 			{Key: "created_at", Value: 1},
 		})
 
@@ -89,7 +209,7 @@ func (s *GroupService) ListGroups(ctx context.Context) ([]*GroupDetails, error)
 	}
 	defer cursor.Close(ctx)
 
-	var groups []*GroupDetails
+	groups := []*GroupDetails{}
 	if err := cursor.All(ctx, &groups); err != nil {
 		return nil, err
 	}
@@ -118,8 +238,6 @@ func (s *GroupService) ExportGroupMatchesCSV(ctx context.Context, groupName stri
 		return "", fmt.Errorf("error decoding matches: %v", err)
 	}
 
-	log.Printf("Found %d completed matches", len(matches))
-
 	// CSV header
 	csvLines := []string{"Date,Team 1 Player 1,Team 1 Player 2,Team 2 Player 1,Team 2 Player 2,Score Team 1,Score Team 2"}
 
@@ -130,19 +248,17 @@ func (s *GroupService) ExportGroupMatchesCSV(ctx context.Context, groupName stri
 			"match_id": match.ID,
 		}).Decode(&detail)
 		if err != nil {
-			log.Printf("Error finding match details for match %s: %v", match.ID.Hex(), err)
+			slog.WarnContext(ctx, "cannot find match details for the export", "match_id", match.ID.Hex(), "error", err)
 			continue
 		}
 
-		log.Printf("Processing match %s from %s", match.ID.Hex(), match.Timestamp)
-
 		// Get player names for team 1
 		var team1Names []string
 		for _, playerID := range detail.Team1 {
 			var player models.Player
 			err = playersColl.FindOne(ctx, bson.M{"_id": playerID}).Decode(&player)
 			if err != nil {
-				log.Printf("Error finding player: %v", err)
+				slog.WarnContext(ctx, "cannot find player for the export", "player_id", playerID.Hex(), "error", err)
 				team1Names = append(team1Names, "Unknown")
 			} else {
 				team1Names = append(team1Names, player.Name)
@@ -155,7 +271,7 @@ func (s *GroupService) ExportGroupMatchesCSV(ctx context.Context, groupName stri
 			var player models.Player
 			err = playersColl.FindOne(ctx, bson.M{"_id": playerID}).Decode(&player)
 			if err != nil {
-				log.Printf("Error finding player: %v", err)
+				slog.WarnContext(ctx, "cannot find player for the export", "player_id", playerID.Hex(), "error", err)
 				team2Names = append(team2Names, "Unknown")
 			} else {
 				team2Names = append(team2Names, player.Name)
@@ -180,8 +296,6 @@ func (s *GroupService) ExportGroupMatchesCSV(ctx context.Context, groupName stri
 			detail.ScoreTeam2,
 		)
 		csvLines = append(csvLines, line)
-
-		log.Printf("Added line: %s", line)
 	}
 
 	return strings.Join(csvLines, "\n"), nil
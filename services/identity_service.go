@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdentityService manages global player identities and their links to group players.
+type IdentityService struct {
+	db *mongo.Database
+}
+
+// NewIdentityService creates a new IdentityService.
+func NewIdentityService(db *mongo.Database) *IdentityService {
+	return &IdentityService{db: db}
+}
+
+// CreateIdentity creates a new global identity protected by a password.
+func (s *IdentityService) CreateIdentity(ctx context.Context, name, password string) (models.Identity, error) {
+	if name == "" {
+		return models.Identity{}, invalid("name", "missing name")
+	}
+	if password == "" {
+		return models.Identity{}, invalid("password", "missing password")
+	}
+
+	hash, err := models.HashPassword(password)
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	identity := models.Identity{
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+
+	res, err := s.db.Collection("identities").InsertOne(ctx, identity)
+	if err != nil {
+		return models.Identity{}, err
+	}
+	identity.ID = res.InsertedID.(primitive.ObjectID)
+	return identity, nil
+}
+
+// GetIdentity retrieves an identity by its ID.
+func (s *IdentityService) GetIdentity(ctx context.Context, identityID primitive.ObjectID) (models.Identity, error) {
+	var identity models.Identity
+	err := s.db.Collection("identities").FindOne(ctx, bson.M{"_id": identityID}).Decode(&identity)
+	if err != nil {
+		return models.Identity{}, notFoundIf(err, "identity not found")
+	}
+	return identity, nil
+}
+
+// Authenticate retrieves an identity and verifies its password.
+func (s *IdentityService) Authenticate(ctx context.Context, identityID primitive.ObjectID, password string) (models.Identity, error) {
+	identity, err := s.GetIdentity(ctx, identityID)
+	if errors.Is(err, ErrNotFound) {
+		return models.Identity{}, unauthorized("identity not found")
+	}
+	if err != nil {
+		return models.Identity{}, err
+	}
+	if !models.CheckPasswordHash(password, identity.PasswordHash) {
+		return models.Identity{}, unauthorized("invalid identity password")
+	}
+	return identity, nil
+}
+
+// LinkPlayer links a group player to an identity. An identity can be linked
+// to at most one player per group.
+func (s *IdentityService) LinkPlayer(ctx context.Context, groupName string, playerID, identityID primitive.ObjectID) error {
+	playersColl := s.db.Collection("players")
+
+	var player models.Player
+	err := playersColl.FindOne(ctx, bson.M{"_id": playerID, "group_name": groupName}).Decode(&player)
+	if err != nil {
+		return notFoundIf(err, "player not found")
+	}
+	if player.IdentityID != nil && *player.IdentityID != identityID {
+		return conflict("player is already linked to another identity")
+	}
+
+	err = playersColl.FindOne(ctx, bson.M{
+		"group_name":  groupName,
+		"identity_id": identityID,
+		"_id":         bson.M{"$ne": playerID},
+	}).Err()
+	if err == nil {
+		return conflict("identity is already linked to another player of this group")
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	_, err = playersColl.UpdateOne(ctx,
+		bson.M{"_id": playerID},
+		bson.M{"$set": bson.M{"identity_id": identityID}},
+	)
+	return err
+}
+
+// UnlinkPlayer removes the identity link of a group player.
+func (s *IdentityService) UnlinkPlayer(ctx context.Context, groupName string, playerID primitive.ObjectID) error {
+	res, err := s.db.Collection("players").UpdateOne(ctx,
+		bson.M{"_id": playerID, "group_name": groupName},
+		bson.M{"$unset": bson.M{"identity_id": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return notFound("player not found")
+	}
+	return nil
+}
+
+// UnlinkIdentityPlayer removes the link between an identity and one of its players.
+func (s *IdentityService) UnlinkIdentityPlayer(ctx context.Context, identityID, playerID primitive.ObjectID) error {
+	res, err := s.db.Collection("players").UpdateOne(ctx,
+		bson.M{"_id": playerID, "identity_id": identityID},
+		bson.M{"$unset": bson.M{"identity_id": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return notFound("player not linked to this identity")
+	}
+	return nil
+}
+
+// LinkedPlayers returns the group players linked to an identity.
+func (s *IdentityService) LinkedPlayers(ctx context.Context, identityID primitive.ObjectID) ([]models.Player, error) {
+	cur, err := s.db.Collection("players").Find(ctx,
+		bson.M{"identity_id": identityID},
+		options.Find().SetSort(bson.M{"group_name": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	players := []models.Player{}
+	if err := cur.All(ctx, &players); err != nil {
+		return nil, err
+	}
+	return players, nil
+}
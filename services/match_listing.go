@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page size bounds of the match listing.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// MaxPageOffset bounds the matches skipped to reach a numbered page, beyond
+// which the clients must follow the cursors.
+const MaxPageOffset = 100000
+
+// MatchFilter restricts a match listing. Zero fields do not filter.
+type MatchFilter struct {
+	Status string // "pending" or "completed"
+
+	// Matches in which the player took part
+	PlayerID *primitive.ObjectID
+
+	// Matches in which both players played in the same team
+	Partners []primitive.ObjectID
+
+	// Matches played from From (inclusive) until To (exclusive)
+	From time.Time
+	To   time.Time
+
+	// Matches played during a calendar year, in UTC
+	Season int
+}
+
+// ListMatches returns up to limit matches of a group following the cursor of
+// the previous page. Matches are ordered newest first by timestamp and then by
+// ID, so that pages neither shift nor repeat when matches are created while
+// paging. The returned cursor is empty on the last page.
+func (s *MatchService) ListMatches(ctx context.Context, groupName string, filter MatchFilter, cursor string, limit int) ([]models.MatchResponse, string, error) {
+	if limit < 1 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	query, err := s.matchQuery(ctx, groupName, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor != "" {
+		ts, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{"$lt": ts}},
+			bson.M{"timestamp": ts, "_id": bson.M{"$lt": id}},
+		}
+	}
+
+	cur, err := s.db.Collection("matches").Find(ctx, query, options.Find().
+		SetSort(matchOrder).
+		SetLimit(int64(limit+1)))
+	if err != nil {
+		return nil, "", err
+	}
+	defer cur.Close(ctx)
+
+	var matches []models.Match
+	if err := cur.All(ctx, &matches); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(matches) > limit {
+		matches = matches[:limit]
+		last := matches[limit-1]
+		next = encodeCursor(last.Timestamp, last.ID)
+	}
+	return s.toResponses(ctx, matches), next, nil
+}
+
+// PendingMatches returns the first limit pending matches of a group, oldest
+// first, however many matches were played since.
+func (s *MatchService) PendingMatches(ctx context.Context, groupName string, limit int) ([]models.MatchResponse, error) {
+	cur, err := s.db.Collection("matches").Find(ctx,
+		bson.M{"group_name": groupName, "status": "pending"},
+		options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var matches []models.Match
+	if err := cur.All(ctx, &matches); err != nil {
+		return nil, err
+	}
+	return s.toResponses(ctx, matches), nil
+}
+
+// CountMatches returns the number of matches of a group matching the filter.
+func (s *MatchService) CountMatches(ctx context.Context, groupName string, filter MatchFilter) (int, error) {
+	query, err := s.matchQuery(ctx, groupName, filter)
+	if err != nil {
+		return 0, err
+	}
+	count, err := s.db.Collection("matches").CountDocuments(ctx, query)
+	return int(count), err
+}
+
+// PageCursor returns the cursor listing the given page of pageSize matches,
+// for the clients of the numbered pages of the v1 API. The first page has
+// an empty cursor.
+func (s *MatchService) PageCursor(ctx context.Context, groupName string, filter MatchFilter, page, pageSize int) (string, error) {
+	if page <= 1 {
+		return "", nil
+	}
+	if pageSize < 1 || page-1 > MaxPageOffset/pageSize {
+		return "", invalid("page", fmt.Sprintf("page too far: at most %d matches can be skipped, use the v2 cursors", MaxPageOffset))
+	}
+	query, err := s.matchQuery(ctx, groupName, filter)
+	if err != nil {
+		return "", err
+	}
+
+	var last models.Match
+	err = s.db.Collection("matches").FindOne(ctx, query, options.FindOne().
+		SetSort(matchOrder).
+		SetSkip(int64((page-1)*pageSize-1)).
+		SetProjection(bson.M{"timestamp": 1})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		// Past the last page; a cursor before any match lists nothing
+		return encodeCursor(time.UnixMilli(0), primitive.NilObjectID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return encodeCursor(last.Timestamp, last.ID), nil
+}
+
+// matchOrder is the order of the match listing.
+var matchOrder = bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}
+
+// matchQuery builds the query of the matches of a group matching the filter.
+func (s *MatchService) matchQuery(ctx context.Context, groupName string, filter MatchFilter) (bson.M, error) {
+	query := bson.M{"group_name": groupName}
+
+	switch filter.Status {
+	case "":
+	case "pending", "completed":
+		query["status"] = filter.Status
+	default:
+		return nil, invalid("status", "status must be pending or completed")
+	}
+
+	from, to := filter.From, filter.To
+	if filter.Season != 0 {
+		if filter.Season < 1 || filter.Season > 9999 {
+			return nil, invalid("season", "invalid season")
+		}
+		start := time.Date(filter.Season, time.January, 1, 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(1, 0, 0)
+		if from.IsZero() || start.After(from) {
+			from = start
+		}
+		if to.IsZero() || end.Before(to) {
+			to = end
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, invalid("to", "the end of the date range must be after its start")
+	}
+	if !from.IsZero() || !to.IsZero() {
+		timestamp := bson.M{}
+		if !from.IsZero() {
+			timestamp["$gte"] = from
+		}
+		if !to.IsZero() {
+			timestamp["$lt"] = to
+		}
+		query["timestamp"] = timestamp
+	}
+
+	var details bson.A
+	if filter.PlayerID != nil {
+		details = append(details, playerReferenceFilter(*filter.PlayerID))
+	}
+	if len(filter.Partners) > 0 {
+		if len(filter.Partners) != 2 || filter.Partners[0] == filter.Partners[1] {
+			return nil, invalid("partners", "partners must be two different players")
+		}
+		details = append(details, bson.M{"$or": bson.A{
+			bson.M{"team1": bson.M{"$all": filter.Partners}},
+			bson.M{"team2": bson.M{"$all": filter.Partners}},
+		}})
+	}
+	if len(details) > 0 {
+		ids, err := s.detailMatchIDs(ctx, bson.M{"$and": details})
+		if err != nil {
+			return nil, err
+		}
+		query["_id"] = bson.M{"$in": ids}
+	}
+	return query, nil
+}
+
+// detailMatchIDs returns the IDs of the matches whose details match the filter.
+func (s *MatchService) detailMatchIDs(ctx context.Context, filter bson.M) ([]primitive.ObjectID, error) {
+	cur, err := s.db.Collection("matchdetails").Find(ctx, filter, options.Find().SetProjection(bson.M{"match_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var details []models.MatchDetail
+	if err := cur.All(ctx, &details); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(details))
+	for _, d := range details {
+		ids = append(ids, d.MatchID)
+	}
+	return ids, nil
+}
+
+// encodeCursor returns the opaque cursor following a match.
+func encodeCursor(ts time.Time, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(ts.UnixMilli(), 10) + ":" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor created by encodeCursor.
+func decodeCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, invalid("cursor", "invalid cursor")
+	}
+	ms, hex, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, invalid("cursor", "invalid cursor")
+	}
+	msec, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, invalid("cursor", "invalid cursor")
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, invalid("cursor", "invalid cursor")
+	}
+	return time.UnixMilli(msec).UTC(), id, nil
+}
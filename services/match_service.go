@@ -2,10 +2,16 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/p4u/padelfriends/events"
 	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/scoring"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,32 +19,40 @@ import (
 )
 
 type MatchService struct {
-	db *mongo.Database
+	db       *mongo.Database
+	bus      *events.Bus
+	webhooks *WebhookService
 }
 
-func NewMatchService(db *mongo.Database) *MatchService {
-	return &MatchService{db: db}
+// NewMatchService creates a new MatchService publishing its changes on bus and
+// queuing them to webhooks, which may both be nil.
+func NewMatchService(db *mongo.Database, bus *events.Bus, webhooks *WebhookService) *MatchService {
+	return &MatchService{db: db, bus: bus, webhooks: webhooks}
 }
 
-// getPlayerInfo retrieves player information by ID
-func (s *MatchService) getPlayerInfo(ctx context.Context, playerID primitive.ObjectID) (models.PlayerInfo, error) {
+// getPlayerInfo retrieves the information of a player of the group by ID
+func (s *MatchService) getPlayerInfo(ctx context.Context, groupName string, playerID primitive.ObjectID) (models.PlayerInfo, error) {
 	playersColl := s.db.Collection("players")
 	var player models.Player
-	err := playersColl.FindOne(ctx, bson.M{"_id": playerID}).Decode(&player)
+	err := playersColl.FindOne(ctx, bson.M{"_id": playerID, "group_name": groupName}).Decode(&player)
+	if err == mongo.ErrNoDocuments {
+		return models.PlayerInfo{}, invalid("player_ids", "player not found: "+playerID.Hex())
+	}
 	if err != nil {
 		return models.PlayerInfo{}, err
 	}
 	return models.PlayerInfo{
-		ID:   player.ID,
-		Name: player.Name,
+		ID:    player.ID,
+		Name:  player.Name,
+		Guest: player.Guest,
 	}, nil
 }
 
-// getPlayersInfo retrieves information for multiple players
-func (s *MatchService) getPlayersInfo(ctx context.Context, playerIDs []primitive.ObjectID) ([]models.PlayerInfo, error) {
+// getPlayersInfo retrieves information for multiple players of the group
+func (s *MatchService) getPlayersInfo(ctx context.Context, groupName string, playerIDs []primitive.ObjectID) ([]models.PlayerInfo, error) {
 	var players []models.PlayerInfo
 	for _, id := range playerIDs {
-		player, err := s.getPlayerInfo(ctx, id)
+		player, err := s.getPlayerInfo(ctx, groupName, id)
 		if err != nil {
 			return nil, err
 		}
@@ -59,82 +73,281 @@ func hasDuplicatePlayers(playerIDs []primitive.ObjectID) bool {
 	return false
 }
 
+// hasGuests reports whether any of the players is a guest
+func hasGuests(players []models.PlayerInfo) bool {
+	for _, p := range players {
+		if p.Guest {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyKeyTTL is how long the result of a batch request is returned
+// again to the retries carrying the same Idempotency-Key.
+const IdempotencyKeyTTL = 24 * time.Hour
+
 // CreateMatch starts a new match record.
 func (s *MatchService) CreateMatch(ctx context.Context, groupName string, playerIDs []primitive.ObjectID) (models.MatchResponse, error) {
+	matches, _, err := s.CreateMatches(ctx, groupName, [][]primitive.ObjectID{playerIDs}, "")
+	if err != nil {
+		return models.MatchResponse{}, err
+	}
+	return matches[0], nil
+}
+
+// CreateMatches creates multiple matches at once. All matches are validated
+// before any is stored, and they are stored in a single transaction.
+//
+// A non-empty idempotency key identifies the request: repeating it within
+// IdempotencyKeyTTL returns the matches created the first time, with replayed
+// set, instead of creating them again. Reusing a key for different matches is
+// a conflict.
+func (s *MatchService) CreateMatches(ctx context.Context, groupName string, matchesPlayerIDs [][]primitive.ObjectID, idempotencyKey string) ([]models.MatchResponse, bool, error) {
+	if len(matchesPlayerIDs) == 0 {
+		return nil, false, invalid("matches", "at least one match is required")
+	}
+
+	keyID := idempotencyKeyID(groupName, idempotencyKey)
+	requestHash := batchHash(matchesPlayerIDs)
+	if idempotencyKey != "" {
+		ids, err := s.findIdempotencyKey(ctx, keyID, requestHash)
+		if err != nil {
+			return nil, false, err
+		}
+		if ids != nil {
+			return s.replay(ctx, ids)
+		}
+	}
+
+	responses := make([]models.MatchResponse, 0, len(matchesPlayerIDs))
+	for i, playerIDs := range matchesPlayerIDs {
+		response, err := s.prepareMatch(ctx, groupName, playerIDs)
+		if err != nil {
+			if len(matchesPlayerIDs) > 1 {
+				err = batchError(i, err)
+			}
+			return nil, false, err
+		}
+		responses = append(responses, response)
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return nil, false, err
+	}
+	defer session.EndSession(ctx)
+
+	replayed, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if idempotencyKey != "" {
+			ids, err := s.findIdempotencyKey(sessCtx, keyID, requestHash)
+			if err != nil || ids != nil {
+				return ids, err
+			}
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(responses))
+		for i := range responses {
+			if err := s.insertMatch(sessCtx, &responses[i], matchesPlayerIDs[i]); err != nil {
+				return nil, err
+			}
+			if err := s.webhooks.enqueue(sessCtx, groupName, events.MatchCreated, responses[i]); err != nil {
+				return nil, err
+			}
+			ids = append(ids, responses[i].ID)
+		}
+
+		if idempotencyKey != "" {
+			_, err := s.db.Collection("idempotency_keys").InsertOne(sessCtx, models.IdempotencyKey{
+				ID:          keyID,
+				GroupName:   groupName,
+				RequestHash: requestHash,
+				MatchIDs:    ids,
+				CreatedAt:   time.Now(),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent retry of the same request stored the key first
+		replayed, err = s.findIdempotencyKey(ctx, keyID, requestHash)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ids, ok := replayed.([]primitive.ObjectID); ok && ids != nil {
+		return s.replay(ctx, ids)
+	}
+
+	for _, response := range responses {
+		s.bus.Publish(groupName, events.MatchCreated, response)
+	}
+	return responses, false, nil
+}
+
+// replay returns the matches created by an earlier request with the same
+// idempotency key. Matches cancelled since then are left out.
+func (s *MatchService) replay(ctx context.Context, ids []primitive.ObjectID) ([]models.MatchResponse, bool, error) {
+	cur, err := s.db.Collection("matches").Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, false, err
+	}
+	defer cur.Close(ctx)
+
+	var matches []models.Match
+	if err := cur.All(ctx, &matches); err != nil {
+		return nil, false, err
+	}
+	return s.toResponses(ctx, matches), true, nil
+}
+
+// prepareMatch validates the players of a new match and returns the match
+// response to store, without an ID yet.
+func (s *MatchService) prepareMatch(ctx context.Context, groupName string, playerIDs []primitive.ObjectID) (models.MatchResponse, error) {
 	if len(playerIDs) != 4 {
-		return models.MatchResponse{}, errors.New("exactly 4 players required for a match")
+		return models.MatchResponse{}, invalid("player_ids", "exactly 4 players required for a match")
 	}
 
 	if hasDuplicatePlayers(playerIDs) {
-		return models.MatchResponse{}, errors.New("duplicate players are not allowed in a match")
+		return models.MatchResponse{}, invalid("player_ids", "duplicate players are not allowed in a match")
 	}
 
-	matchesColl := s.db.Collection("matches")
-	detailsColl := s.db.Collection("matchdetails")
+	team1Players, err := s.getPlayersInfo(ctx, groupName, playerIDs[:2])
+	if err != nil {
+		return models.MatchResponse{}, err
+	}
 
-	match := models.Match{
+	team2Players, err := s.getPlayersInfo(ctx, groupName, playerIDs[2:])
+	if err != nil {
+		return models.MatchResponse{}, err
+	}
+
+	// Deactivated players keep their history but play no new matches
+	var inactive models.Player
+	err = s.db.Collection("players").FindOne(ctx, bson.M{
+		"_id":        bson.M{"$in": playerIDs},
+		"group_name": groupName,
+		"inactive":   true,
+	}).Decode(&inactive)
+	if err == nil {
+		return models.MatchResponse{}, invalid("player_ids", "player is deactivated: "+inactive.Name)
+	}
+	if err != mongo.ErrNoDocuments {
+		return models.MatchResponse{}, err
+	}
+
+	return models.MatchResponse{
 		GroupName: groupName,
 		Timestamp: time.Now(),
+		Team1:     team1Players,
+		Team2:     team2Players,
 		Status:    "pending",
+		HasGuests: hasGuests(team1Players) || hasGuests(team2Players),
+		Version:   1,
+	}, nil
+}
+
+// insertMatch stores a prepared match and its details, setting its ID.
+func (s *MatchService) insertMatch(ctx context.Context, response *models.MatchResponse, playerIDs []primitive.ObjectID) error {
+	match := models.Match{
+		GroupName: response.GroupName,
+		Timestamp: response.Timestamp,
+		Status:    response.Status,
+		HasGuests: response.HasGuests,
+		Version:   response.Version,
 	}
-	res, err := matchesColl.InsertOne(ctx, match)
+	res, err := s.db.Collection("matches").InsertOne(ctx, match)
 	if err != nil {
-		return models.MatchResponse{}, err
+		return err
 	}
-	match.ID = res.InsertedID.(primitive.ObjectID)
+	response.ID = res.InsertedID.(primitive.ObjectID)
 
 	detail := models.MatchDetail{
-		MatchID:    match.ID,
+		MatchID:    response.ID,
 		Team1:      playerIDs[:2],
 		Team2:      playerIDs[2:],
 		ScoreTeam1: 0,
 		ScoreTeam2: 0,
 	}
+	_, err = s.db.Collection("matchdetails").InsertOne(ctx, detail)
+	return err
+}
 
-	_, err = detailsColl.InsertOne(ctx, detail)
-	if err != nil {
-		return models.MatchResponse{}, err
-	}
+// findIdempotencyKey returns the matches created by an earlier request with
+// the same key, or nil if there is none. An expired key is removed.
+func (s *MatchService) findIdempotencyKey(ctx context.Context, keyID, requestHash string) ([]primitive.ObjectID, error) {
+	keysColl := s.db.Collection("idempotency_keys")
 
-	team1Players, err := s.getPlayersInfo(ctx, detail.Team1)
-	if err != nil {
-		return models.MatchResponse{}, err
+	var key models.IdempotencyKey
+	err := keysColl.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
 	}
-
-	team2Players, err := s.getPlayersInfo(ctx, detail.Team2)
 	if err != nil {
-		return models.MatchResponse{}, err
+		return nil, err
 	}
 
-	response := models.MatchResponse{
-		ID:         match.ID,
-		GroupName:  match.GroupName,
-		Timestamp:  match.Timestamp,
-		Team1:      team1Players,
-		Team2:      team2Players,
-		ScoreTeam1: detail.ScoreTeam1,
-		ScoreTeam2: detail.ScoreTeam2,
-		Status:     match.Status,
+	if time.Since(key.CreatedAt) > IdempotencyKeyTTL {
+		_, err := keysColl.DeleteOne(ctx, bson.M{"_id": keyID})
+		return nil, err
 	}
+	if key.RequestHash != requestHash {
+		return nil, conflict("idempotency key already used for a different request")
+	}
+	if key.MatchIDs == nil {
+		key.MatchIDs = []primitive.ObjectID{}
+	}
+	return key.MatchIDs, nil
+}
 
-	return response, nil
+// idempotencyKeyID identifies the idempotency key of a group. The length of
+// the group name is hashed first, so that no other group and key, such as
+// "a:b" and "c" against "a" and "b:c", share the same identifier.
+func idempotencyKeyID(groupName, key string) string {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint64(len(groupName)))
+	h.Write([]byte(groupName))
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-// CreateMatches creates multiple matches at once
-func (s *MatchService) CreateMatches(ctx context.Context, groupName string, matchesPlayerIDs [][]primitive.ObjectID) ([]models.MatchResponse, error) {
-	var responses []models.MatchResponse
+// batchHash fingerprints the players of a batch request.
+func batchHash(matchesPlayerIDs [][]primitive.ObjectID) string {
+	h := sha256.New()
 	for _, playerIDs := range matchesPlayerIDs {
-		match, err := s.CreateMatch(ctx, groupName, playerIDs)
-		if err != nil {
-			return nil, err
+		for _, id := range playerIDs {
+			h.Write(id[:])
 		}
-		responses = append(responses, match)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// batchError prefixes the message of a validation error with the position
+// of the offending match in a batch.
+func batchError(index int, err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
 	}
-	return responses, nil
+	field := fmt.Sprintf("matches[%d]", index)
+	if e.Field != "" {
+		field += "." + e.Field
+	}
+	return &Error{Kind: e.Kind, Field: field, Message: fmt.Sprintf("match %d: %s", index+1, e.Message)}
 }
 
-// CancelMatch deletes a match and its details
-func (s *MatchService) CancelMatch(ctx context.Context, matchID primitive.ObjectID) error {
+// AnyVersion disables the version check of the match updates.
+const AnyVersion = -1
+
+// CancelMatch deletes a pending match of a group and its details. Unless
+// version is AnyVersion, the match must still be at that version.
+func (s *MatchService) CancelMatch(ctx context.Context, groupName string, matchID primitive.ObjectID, version int) error {
 	matchesColl := s.db.Collection("matches")
 	detailsColl := s.db.Collection("matchdetails")
 
@@ -144,52 +357,84 @@ func (s *MatchService) CancelMatch(ctx context.Context, matchID primitive.Object
 	}
 	defer session.EndSession(ctx)
 
+	var match models.Match
 	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		_, err := detailsColl.DeleteOne(sessCtx, bson.M{"match_id": matchID})
+		err := matchesColl.FindOneAndDelete(sessCtx, versionFilter(bson.M{
+			"_id":        matchID,
+			"group_name": groupName,
+			"status":     "pending",
+		}, version)).Decode(&match)
+		if err == mongo.ErrNoDocuments {
+			return nil, s.updateError(sessCtx, bson.M{"_id": matchID, "group_name": groupName}, "pending", version)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		result, err := matchesColl.DeleteOne(sessCtx, bson.M{
-			"_id":    matchID,
-			"status": "pending",
-		})
+		_, err = detailsColl.DeleteOne(sessCtx, bson.M{"match_id": matchID})
 		if err != nil {
 			return nil, err
 		}
 
-		if result.DeletedCount == 0 {
-			return nil, errors.New("match not found or already completed")
-		}
-
-		return nil, nil
+		return nil, s.webhooks.enqueue(sessCtx, match.GroupName, events.MatchCancelled, cancelledEvent(matchID))
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	s.bus.Publish(match.GroupName, events.MatchCancelled, cancelledEvent(matchID))
+	return nil
 }
 
-// GetRecentMatches returns the last 20 matches for a group
-func (s *MatchService) GetRecentMatches(ctx context.Context, groupName string) ([]models.MatchResponse, error) {
-	matchesColl := s.db.Collection("matches")
-	detailsColl := s.db.Collection("matchdetails")
-
-	// Get last 20 matches
-	findOptions := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetLimit(20)
+// ListPlayerMatches returns the matches, across all groups, in which any of the
+// given players took part, newest first.
+func (s *MatchService) ListPlayerMatches(ctx context.Context, playerIDs []primitive.ObjectID) ([]models.MatchResponse, error) {
+	if len(playerIDs) == 0 {
+		return []models.MatchResponse{}, nil
+	}
 
-	cur, err := matchesColl.Find(ctx, bson.M{"group_name": groupName}, findOptions)
+	cur, err := s.db.Collection("matchdetails").Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"team1": bson.M{"$in": playerIDs}},
+		bson.M{"team2": bson.M{"$in": playerIDs}},
+	}}, options.Find().SetProjection(bson.M{"match_id": 1}))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
+	var details []models.MatchDetail
+	if err := cur.All(ctx, &details); err != nil {
+		return nil, err
+	}
+
+	matchIDs := make([]primitive.ObjectID, 0, len(details))
+	for _, d := range details {
+		matchIDs = append(matchIDs, d.MatchID)
+	}
+
+	mcur, err := s.db.Collection("matches").Find(ctx,
+		bson.M{"_id": bson.M{"$in": matchIDs}},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer mcur.Close(ctx)
+
 	var matches []models.Match
-	if err := cur.All(ctx, &matches); err != nil {
+	if err := mcur.All(ctx, &matches); err != nil {
 		return nil, err
 	}
 
-	var responses []models.MatchResponse
+	return s.toResponses(ctx, matches), nil
+}
+
+// toResponses resolves details and player names of the matches. Matches whose
+// details or players cannot be loaded are skipped.
+func (s *MatchService) toResponses(ctx context.Context, matches []models.Match) []models.MatchResponse {
+	detailsColl := s.db.Collection("matchdetails")
+
+	responses := []models.MatchResponse{}
 	for _, match := range matches {
 		var detail models.MatchDetail
 		err := detailsColl.FindOne(ctx, bson.M{"match_id": match.ID}).Decode(&detail)
@@ -197,12 +442,12 @@ func (s *MatchService) GetRecentMatches(ctx context.Context, groupName string) (
 			continue
 		}
 
-		team1Players, err := s.getPlayersInfo(ctx, detail.Team1)
+		team1Players, err := s.getPlayersInfo(ctx, match.GroupName, detail.Team1)
 		if err != nil {
 			continue
 		}
 
-		team2Players, err := s.getPlayersInfo(ctx, detail.Team2)
+		team2Players, err := s.getPlayersInfo(ctx, match.GroupName, detail.Team2)
 		if err != nil {
 			continue
 		}
@@ -215,85 +460,104 @@ func (s *MatchService) GetRecentMatches(ctx context.Context, groupName string) (
 			Team2:      team2Players,
 			ScoreTeam1: detail.ScoreTeam1,
 			ScoreTeam2: detail.ScoreTeam2,
+			Sets:       detail.Sets,
 			Status:     match.Status,
+			HasGuests:  match.HasGuests,
+			Disputed:   match.Disputed,
+			Version:    match.Version,
 		}
 		responses = append(responses, response)
 	}
-
-	return responses, nil
+	return responses
 }
 
-// ListMatches returns all matches for a group with pagination
-func (s *MatchService) ListMatches(ctx context.Context, groupName string, page, pageSize int) ([]models.MatchResponse, int, error) {
-	matchesColl := s.db.Collection("matches")
-	detailsColl := s.db.Collection("matchdetails")
-
-	// Get total count
-	totalCount, err := matchesColl.CountDocuments(ctx, bson.M{"group_name": groupName})
+// GetMatch retrieves a match of a group by its ID.
+func (s *MatchService) GetMatch(ctx context.Context, groupName string, matchID primitive.ObjectID) (models.Match, error) {
+	var match models.Match
+	err := s.db.Collection("matches").FindOne(ctx, bson.M{"_id": matchID, "group_name": groupName}).Decode(&match)
 	if err != nil {
-		return nil, 0, err
+		return models.Match{}, notFoundIf(err, "match not found")
 	}
+	return match, nil
+}
 
-	// Calculate skip value
-	skip := (page - 1) * pageSize
-
-	// Get matches with pagination
-	findOptions := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(pageSize))
+// CountCompletedSince returns the number of matches of a group completed since
+// the given time. Matches completed before the completion time was recorded
+// count from their creation.
+func (s *MatchService) CountCompletedSince(ctx context.Context, groupName string, since time.Time) (int64, error) {
+	return s.db.Collection("matches").CountDocuments(ctx, bson.M{
+		"group_name": groupName,
+		"status":     "completed",
+		"$or": bson.A{
+			bson.M{"completed_at": bson.M{"$gte": since}},
+			bson.M{"completed_at": bson.M{"$exists": false}, "timestamp": bson.M{"$gte": since}},
+		},
+	})
+}
 
-	cur, err := matchesColl.Find(ctx, bson.M{"group_name": groupName}, findOptions)
+// GetMatchResponse retrieves a match of a group with its teams and scores.
+func (s *MatchService) GetMatchResponse(ctx context.Context, groupName string, matchID primitive.ObjectID) (models.MatchResponse, error) {
+	match, err := s.GetMatch(ctx, groupName, matchID)
 	if err != nil {
-		return nil, 0, err
+		return models.MatchResponse{}, err
 	}
-	defer cur.Close(ctx)
-
-	var matches []models.Match
-	if err := cur.All(ctx, &matches); err != nil {
-		return nil, 0, err
+	responses := s.toResponses(ctx, []models.Match{match})
+	if len(responses) == 0 {
+		return models.MatchResponse{}, notFound("match details not found")
 	}
+	return responses[0], nil
+}
 
-	var responses []models.MatchResponse
-	for _, match := range matches {
-		var detail models.MatchDetail
-		err := detailsColl.FindOne(ctx, bson.M{"match_id": match.ID}).Decode(&detail)
-		if err != nil {
-			continue
-		}
-
-		team1Players, err := s.getPlayersInfo(ctx, detail.Team1)
-		if err != nil {
-			continue
-		}
-
-		team2Players, err := s.getPlayersInfo(ctx, detail.Team2)
-		if err != nil {
-			continue
-		}
-
-		response := models.MatchResponse{
-			ID:         match.ID,
-			GroupName:  match.GroupName,
-			Timestamp:  match.Timestamp,
-			Team1:      team1Players,
-			Team2:      team2Players,
-			ScoreTeam1: detail.ScoreTeam1,
-			ScoreTeam2: detail.ScoreTeam2,
-			Status:     match.Status,
-		}
-		responses = append(responses, response)
+// DisputeResult flags the result of a completed match as contested. Unless
+// version is AnyVersion, the match must still be at that version.
+func (s *MatchService) DisputeResult(ctx context.Context, groupName string, matchID primitive.ObjectID, version int, reason string) error {
+	res, err := s.db.Collection("matches").UpdateOne(ctx,
+		versionFilter(bson.M{"_id": matchID, "group_name": groupName, "status": "completed"}, version),
+		bson.M{
+			"$set": bson.M{"disputed": true, "dispute_reason": reason},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return s.updateError(ctx, bson.M{"_id": matchID, "group_name": groupName}, "completed", version)
 	}
 
-	return responses, int(totalCount), nil
+	s.bus.Publish(groupName, events.ResultDisputed, map[string]interface{}{
+		"match_id": matchID,
+		"reason":   reason,
+	})
+	return nil
 }
 
-// SubmitResults updates the match detail with final scores.
-func (s *MatchService) SubmitResults(ctx context.Context, matchID primitive.ObjectID, scoreTeam1, scoreTeam2 int) error {
+// SubmitResults records the final scores of a pending match of a group.
+// Unless version is AnyVersion, the match must still be at that version.
+func (s *MatchService) SubmitResults(ctx context.Context, groupName string, matchID primitive.ObjectID, version int, scoreTeam1, scoreTeam2 int) error {
 	if scoreTeam1 < 0 || scoreTeam1 > 10 || scoreTeam2 < 0 || scoreTeam2 > 10 {
-		return errors.New("invalid scores")
+		return invalid("score", "invalid scores")
 	}
+	return s.submitResults(ctx, groupName, matchID, version, scoreTeam1, scoreTeam2, nil)
+}
 
+// SubmitScoredResults records the result of a match played set by set in the
+// given format. The sets are stored along with a score agreeing with the
+// winner: the games of a one-set match, or the sets won by each team, since
+// the team winning the most games may lose a longer match.
+func (s *MatchService) SubmitScoredResults(ctx context.Context, groupName string, matchID primitive.ObjectID, format scoring.Format, sets [][2]int) error {
+	result, err := format.Decide(sets)
+	if err != nil {
+		return invalid("sets", err.Error())
+	}
+	score := result.SetsWon
+	if format.Sets == 1 {
+		score = sets[0]
+	}
+	return s.submitResults(ctx, groupName, matchID, AnyVersion, score[0], score[1], sets)
+}
+
+func (s *MatchService) submitResults(ctx context.Context, groupName string, matchID primitive.ObjectID, version int, scoreTeam1, scoreTeam2 int, sets [][2]int) error {
 	matchesColl := s.db.Collection("matches")
 	detailsColl := s.db.Collection("matchdetails")
 
@@ -303,30 +567,90 @@ func (s *MatchService) SubmitResults(ctx context.Context, matchID primitive.Obje
 	}
 	defer session.EndSession(ctx)
 
+	var match models.Match
 	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
-		_, err := matchesColl.UpdateOne(
+		match = models.Match{}
+		err := matchesColl.FindOneAndUpdate(
 			sessCtx,
-			bson.M{"_id": matchID, "status": "pending"},
-			bson.M{"$set": bson.M{"status": "completed"}},
-		)
+			versionFilter(bson.M{"_id": matchID, "group_name": groupName, "status": "pending"}, version),
+			bson.M{
+				"$set": bson.M{"status": "completed", "completed_at": time.Now()},
+				"$inc": bson.M{"version": 1},
+			},
+		).Decode(&match)
+		if err == mongo.ErrNoDocuments {
+			return nil, s.updateError(sessCtx, bson.M{"_id": matchID, "group_name": groupName}, "pending", version)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		_, err = detailsColl.UpdateOne(
-			sessCtx,
-			bson.M{"match_id": matchID},
-			bson.M{"$set": bson.M{
-				"score_team1": scoreTeam1,
-				"score_team2": scoreTeam2,
-			}},
-		)
+		set := bson.M{
+			"score_team1": scoreTeam1,
+			"score_team2": scoreTeam2,
+		}
+		if len(sets) > 0 {
+			set["sets"] = sets
+		}
+		_, err = detailsColl.UpdateOne(sessCtx, bson.M{"match_id": matchID}, bson.M{"$set": set})
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		return nil, s.webhooks.enqueue(sessCtx, match.GroupName, events.ResultSubmitted,
+			resultEvent(matchID, scoreTeam1, scoreTeam2))
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	s.bus.Publish(match.GroupName, events.ResultSubmitted, resultEvent(matchID, scoreTeam1, scoreTeam2))
+	return nil
+}
+
+// cancelledEvent is the data of the event of a cancelled match.
+func cancelledEvent(matchID primitive.ObjectID) map[string]interface{} {
+	return map[string]interface{}{
+		"match_id": matchID,
+	}
+}
+
+// resultEvent is the data of the event of a submitted result.
+func resultEvent(matchID primitive.ObjectID, scoreTeam1, scoreTeam2 int) map[string]interface{} {
+	return map[string]interface{}{
+		"match_id":    matchID,
+		"score_team1": scoreTeam1,
+		"score_team2": scoreTeam2,
+		"status":      "completed",
+	}
+}
+
+// versionFilter restricts the filter of a match update to the given version.
+// Matches stored before versioning count as version 0.
+func versionFilter(filter bson.M, version int) bson.M {
+	switch {
+	case version == AnyVersion:
+	case version == 0:
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+// updateError explains why a conditional update of the match selected by
+// filter matched nothing: the match is missing, at another version or not in
+// the expected status.
+func (s *MatchService) updateError(ctx context.Context, filter bson.M, status string, version int) error {
+	var match models.Match
+	if err := s.db.Collection("matches").FindOne(ctx, filter).Decode(&match); err != nil {
+		return notFoundIf(err, "match not found")
+	}
+	if version != AnyVersion && match.Version != version {
+		return conflict(fmt.Sprintf("match was modified: it is at version %d, not %d", match.Version, version))
+	}
+	if match.Status != status {
+		return conflict(fmt.Sprintf("match is %s, not %s", match.Status, status))
+	}
+	return conflict("match was modified concurrently")
 }
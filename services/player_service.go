@@ -2,8 +2,10 @@ package services
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"net/mail"
 
+	"github.com/p4u/padelfriends/events"
 	"github.com/p4u/padelfriends/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,52 +14,395 @@ import (
 )
 
 type PlayerService struct {
-	db *mongo.Database
+	db       *mongo.Database
+	bus      *events.Bus
+	webhooks *WebhookService
 }
 
-func NewPlayerService(db *mongo.Database) *PlayerService {
-	return &PlayerService{db: db}
+// NewPlayerService creates a new PlayerService publishing its changes on bus and
+// queuing them to webhooks, which may both be nil.
+func NewPlayerService(db *mongo.Database, bus *events.Bus, webhooks *WebhookService) *PlayerService {
+	return &PlayerService{db: db, bus: bus, webhooks: webhooks}
 }
 
-// AddPlayer adds a player to a group if not duplicate.
-func (s *PlayerService) AddPlayer(ctx context.Context, groupName string, name string) (models.Player, error) {
+// AddPlayer adds a player to a group if not duplicate. Guest players can take
+// part in matches but are kept apart from the group statistics.
+func (s *PlayerService) AddPlayer(ctx context.Context, groupName string, name string, guest bool) (models.Player, error) {
 	playersColl := s.db.Collection("players")
 
-	// Check duplicate
-	err := playersColl.FindOne(ctx, bson.M{"group_name": groupName, "name": name}).Err()
-	if err == nil {
-		return models.Player{}, errors.New("player already exists in this group")
+	if name == "" {
+		return models.Player{}, invalid("name", "player name is required")
 	}
-	if err != mongo.ErrNoDocuments {
+
+	// Check duplicate
+	if err := s.checkNameAvailable(ctx, groupName, name); err != nil {
 		return models.Player{}, err
 	}
 
 	p := models.Player{
 		GroupName: groupName,
 		Name:      name,
+		Guest:     guest,
 	}
 
-	res, err := playersColl.InsertOne(ctx, p)
+	session, err := s.db.Client().StartSession()
 	if err != nil {
 		return models.Player{}, err
 	}
-	p.ID = res.InsertedID.(primitive.ObjectID)
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		p.ID = primitive.NilObjectID
+		res, err := playersColl.InsertOne(sessCtx, p)
+		if err != nil {
+			return nil, err
+		}
+		p.ID = res.InsertedID.(primitive.ObjectID)
+		return nil, s.webhooks.enqueue(sessCtx, groupName, events.PlayerAdded, p)
+	})
+	if err != nil {
+		return models.Player{}, conflictIfDuplicate(err, "player already exists in this group")
+	}
+
+	s.bus.Publish(groupName, events.PlayerAdded, p)
 	return p, nil
 }
 
-// ListPlayers lists all players for a given group.
-func (s *PlayerService) ListPlayers(ctx context.Context, groupName string) ([]models.Player, error) {
+// ListPlayers lists the players of a group. Deactivated players are only
+// included when includeInactive is set.
+func (s *PlayerService) ListPlayers(ctx context.Context, groupName string, includeInactive bool) ([]models.Player, error) {
 	playersColl := s.db.Collection("players")
 
-	cur, err := playersColl.Find(ctx, bson.M{"group_name": groupName}, options.Find().SetSort(bson.M{"name": 1}))
+	filter := bson.M{"group_name": groupName}
+	if !includeInactive {
+		filter["inactive"] = bson.M{"$ne": true}
+	}
+
+	cur, err := playersColl.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
 
-	var players []models.Player
+	players := []models.Player{}
 	if err := cur.All(ctx, &players); err != nil {
 		return nil, err
 	}
 	return players, nil
 }
+
+// GetPlayer retrieves a player of a group by its ID.
+func (s *PlayerService) GetPlayer(ctx context.Context, groupName string, playerID primitive.ObjectID) (models.Player, error) {
+	playersColl := s.db.Collection("players")
+	var p models.Player
+	err := playersColl.FindOne(ctx, bson.M{"_id": playerID, "group_name": groupName}).Decode(&p)
+	if err != nil {
+		return models.Player{}, notFoundIf(err, "player not found")
+	}
+	return p, nil
+}
+
+// RenamePlayer changes the name of a player, keeping names unique within the group.
+func (s *PlayerService) RenamePlayer(ctx context.Context, groupName string, playerID primitive.ObjectID, name string) (models.Player, error) {
+	if name == "" {
+		return models.Player{}, invalid("name", "player name is required")
+	}
+
+	p, err := s.GetPlayer(ctx, groupName, playerID)
+	if err != nil {
+		return models.Player{}, err
+	}
+	if p.Name == name {
+		return p, nil
+	}
+
+	if err := s.checkNameAvailable(ctx, groupName, name); err != nil {
+		return models.Player{}, err
+	}
+
+	_, err = s.db.Collection("players").UpdateOne(ctx,
+		bson.M{"_id": playerID},
+		bson.M{"$set": bson.M{"name": name}},
+	)
+	if err != nil {
+		return models.Player{}, conflictIfDuplicate(err, "player already exists in this group")
+	}
+	p.Name = name
+	return p, nil
+}
+
+// UpdateAttributes replaces the playing preferences and contact information of a player.
+func (s *PlayerService) UpdateAttributes(ctx context.Context, groupName string, playerID primitive.ObjectID, attrs models.PlayerAttributes) (models.Player, error) {
+	if err := validateAttributes(attrs); err != nil {
+		return models.Player{}, err
+	}
+
+	p, err := s.GetPlayer(ctx, groupName, playerID)
+	if err != nil {
+		return models.Player{}, err
+	}
+
+	update := newUpdate()
+	setAttributes(update, attrs)
+	_, err = s.db.Collection("players").UpdateOne(ctx, bson.M{"_id": playerID}, pruneUpdate(update))
+	if err != nil {
+		return models.Player{}, err
+	}
+	p.PlayerAttributes = attrs
+	return p, nil
+}
+
+// PlayerUpdate holds the changes to a player; nil fields are left unchanged.
+type PlayerUpdate struct {
+	Name       *string
+	Inactive   *bool
+	Attributes *models.PlayerAttributes
+}
+
+// UpdatePlayer checks every change first and then applies them all in a
+// single write, so that a rejected change leaves the player untouched.
+func (s *PlayerService) UpdatePlayer(ctx context.Context, groupName string, playerID primitive.ObjectID, u PlayerUpdate) (models.Player, error) {
+	if u.Name != nil && *u.Name == "" {
+		return models.Player{}, invalid("name", "player name is required")
+	}
+	if u.Attributes != nil {
+		if err := validateAttributes(*u.Attributes); err != nil {
+			return models.Player{}, err
+		}
+	}
+
+	p, err := s.GetPlayer(ctx, groupName, playerID)
+	if err != nil {
+		return models.Player{}, err
+	}
+
+	update := newUpdate()
+	if u.Name != nil && *u.Name != p.Name {
+		if err := s.checkNameAvailable(ctx, groupName, *u.Name); err != nil {
+			return models.Player{}, err
+		}
+		update["$set"].(bson.M)["name"] = *u.Name
+		p.Name = *u.Name
+	}
+	if u.Attributes != nil {
+		setAttributes(update, *u.Attributes)
+		p.PlayerAttributes = *u.Attributes
+	}
+	if u.Inactive != nil {
+		update["$set"].(bson.M)["inactive"] = *u.Inactive
+		p.Inactive = *u.Inactive
+	}
+	if len(pruneUpdate(update)) == 0 {
+		return p, nil
+	}
+
+	_, err = s.db.Collection("players").UpdateOne(ctx, bson.M{"_id": playerID}, update)
+	if err != nil {
+		return models.Player{}, conflictIfDuplicate(err, "player already exists in this group")
+	}
+	return p, nil
+}
+
+// SetAvatar records the stored avatar file of a player, returning the previous one.
+func (s *PlayerService) SetAvatar(ctx context.Context, groupName string, playerID primitive.ObjectID, avatar string) (string, error) {
+	p, err := s.GetPlayer(ctx, groupName, playerID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Collection("players").UpdateOne(ctx,
+		bson.M{"_id": playerID},
+		bson.M{"$set": bson.M{"avatar": avatar}},
+	)
+	if err != nil {
+		return "", err
+	}
+	return p.Avatar, nil
+}
+
+// SetPlayerActive deactivates or reactivates a player. Deactivated players are
+// hidden from selection but their match history is kept.
+func (s *PlayerService) SetPlayerActive(ctx context.Context, groupName string, playerID primitive.ObjectID, active bool) (models.Player, error) {
+	p, err := s.GetPlayer(ctx, groupName, playerID)
+	if err != nil {
+		return models.Player{}, err
+	}
+
+	_, err = s.db.Collection("players").UpdateOne(ctx,
+		bson.M{"_id": playerID},
+		bson.M{"$set": bson.M{"inactive": !active}},
+	)
+	if err != nil {
+		return models.Player{}, err
+	}
+	p.Inactive = !active
+	return p, nil
+}
+
+// DeletePlayer removes a player permanently. Players referenced by any match
+// cannot be deleted; deactivate or merge them instead.
+func (s *PlayerService) DeletePlayer(ctx context.Context, groupName string, playerID primitive.ObjectID) error {
+	if _, err := s.GetPlayer(ctx, groupName, playerID); err != nil {
+		return err
+	}
+
+	count, err := s.db.Collection("matchdetails").CountDocuments(ctx, playerReferenceFilter(playerID))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return conflict("player has matches and cannot be deleted")
+	}
+
+	_, err = s.db.Collection("players").DeleteOne(ctx, bson.M{"_id": playerID})
+	return err
+}
+
+// MergePlayers rewrites every match reference from the duplicate player to the
+// canonical one, updates the guest flags of these matches and removes the
+// duplicate, all within a single transaction.
+// It returns the number of match details that were rewritten.
+func (s *PlayerService) MergePlayers(ctx context.Context, groupName string, duplicateID, canonicalID primitive.ObjectID) (int64, error) {
+	if duplicateID == canonicalID {
+		return 0, invalid("into", "cannot merge a player into itself")
+	}
+	if _, err := s.GetPlayer(ctx, groupName, duplicateID); err != nil {
+		return 0, err
+	}
+	if _, err := s.GetPlayer(ctx, groupName, canonicalID); err != nil {
+		return 0, err
+	}
+
+	playersColl := s.db.Collection("players")
+	detailsColl := s.db.Collection("matchdetails")
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	merged, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// Both players in the same match would leave a team with a repeated player
+		conflicts, err := detailsColl.CountDocuments(sessCtx, bson.M{"$and": bson.A{
+			playerReferenceFilter(duplicateID),
+			playerReferenceFilter(canonicalID),
+		}})
+		if err != nil {
+			return nil, err
+		}
+		if conflicts > 0 {
+			return nil, conflict("both players appear in the same match and cannot be merged")
+		}
+
+		// The matches of the duplicate, whose guest flags may change
+		matchIDs, err := detailsColl.Distinct(sessCtx, "match_id", playerReferenceFilter(duplicateID))
+		if err != nil {
+			return nil, err
+		}
+
+		var rewritten int64
+		for _, team := range []string{"team1", "team2"} {
+			res, err := detailsColl.UpdateMany(sessCtx,
+				bson.M{team: duplicateID},
+				bson.M{"$set": bson.M{team + ".$": canonicalID}},
+			)
+			if err != nil {
+				return nil, err
+			}
+			rewritten += res.ModifiedCount
+		}
+
+		if _, err := playersColl.DeleteOne(sessCtx, bson.M{"_id": duplicateID}); err != nil {
+			return nil, err
+		}
+		if len(matchIDs) > 0 {
+			if _, err := updateGuestFlags(sessCtx, s.db, bson.M{"_id": bson.M{"$in": matchIDs}}); err != nil {
+				return nil, err
+			}
+		}
+		return rewritten, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return merged.(int64), nil
+}
+
+// checkNameAvailable returns an error if the group already has a player with the given name.
+func (s *PlayerService) checkNameAvailable(ctx context.Context, groupName, name string) error {
+	err := s.db.Collection("players").FindOne(ctx, bson.M{"group_name": groupName, "name": name}).Err()
+	if err == nil {
+		return conflict("player already exists in this group")
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+	return nil
+}
+
+// validateAttributes checks the player attribute values.
+func validateAttributes(attrs models.PlayerAttributes) error {
+	switch attrs.PreferredSide {
+	case "", models.SideDrive, models.SideReves, models.SideBoth:
+	default:
+		return invalid("preferred_side", "preferred_side must be drive, reves or both")
+	}
+	switch attrs.Handedness {
+	case "", models.HandRight, models.HandLeft:
+	default:
+		return invalid("handedness", "handedness must be right or left")
+	}
+	if attrs.Level != 0 && (attrs.Level < models.MinPlayerLevel || attrs.Level > models.MaxPlayerLevel) {
+		return invalid("level", fmt.Sprintf("level must be between %.0f and %.0f", models.MinPlayerLevel, models.MaxPlayerLevel))
+	}
+	if attrs.Contact != nil && attrs.Contact.Email != "" {
+		if addr, err := mail.ParseAddress(attrs.Contact.Email); err != nil || addr.Address != attrs.Contact.Email {
+			return invalid("contact.email", "invalid contact email address")
+		}
+	}
+	return nil
+}
+
+// newUpdate returns an empty update with $set and $unset parts.
+func newUpdate() bson.M {
+	return bson.M{
+		"$set":   bson.M{},
+		"$unset": bson.M{},
+	}
+}
+
+// pruneUpdate removes the empty parts of an update, which MongoDB rejects.
+func pruneUpdate(update bson.M) bson.M {
+	for op, fields := range update {
+		if len(fields.(bson.M)) == 0 {
+			delete(update, op)
+		}
+	}
+	return update
+}
+
+// setAttributes adds the player attributes to an update, unsetting the empty ones.
+func setAttributes(update bson.M, attrs models.PlayerAttributes) {
+	setOrUnset(update, "preferred_side", attrs.PreferredSide, attrs.PreferredSide != "")
+	setOrUnset(update, "handedness", attrs.Handedness, attrs.Handedness != "")
+	setOrUnset(update, "level", attrs.Level, attrs.Level != 0)
+	setOrUnset(update, "contact", attrs.Contact, attrs.Contact != nil && *attrs.Contact != models.PlayerContact{})
+}
+
+// setOrUnset adds the field to the $set or the $unset part of an update.
+func setOrUnset(update bson.M, field string, value interface{}, set bool) {
+	if set {
+		update["$set"].(bson.M)[field] = value
+	} else {
+		update["$unset"].(bson.M)[field] = ""
+	}
+}
+
+// playerReferenceFilter matches the match details in which the player took part.
+func playerReferenceFilter(playerID primitive.ObjectID) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"team1": playerID},
+		bson.M{"team2": playerID},
+	}}
+}
@@ -3,9 +3,11 @@ package services
 import (
 	"context"
 
+	"github.com/p4u/padelfriends/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type StatsService struct {
@@ -19,6 +21,7 @@ func NewStatsService(db *mongo.Database) *StatsService {
 type PlayerStats struct {
 	PlayerID   primitive.ObjectID `json:"player_id"`
 	PlayerName string             `json:"player_name"`
+	Guest      bool               `json:"guest"`
 
 	// Game Statistics
 	TotalGames   int     `json:"total_games"`
@@ -33,19 +36,150 @@ type PlayerStats struct {
 	PointsLost    int     `json:"points_lost"`
 	PointWinRate  float64 `json:"point_win_rate"`
 	PointLossRate float64 `json:"point_loss_rate"`
+
+	// Games played together with guests, only counted when the group marks
+	// guest matches instead of excluding them
+	GuestGames int `json:"guest_games"`
 }
 
-// ComputeStats calculates statistics for all players in a group
+// ComputeStats calculates statistics for all players in a group.
+// Matches involving guests are excluded or marked according to the group settings.
 func (s *StatsService) ComputeStats(ctx context.Context, groupName string) ([]PlayerStats, error) {
+	return s.computeStats(ctx, groupName, nil)
+}
+
+// GroupPlayerStats holds the statistics of a player within one of its groups.
+type GroupPlayerStats struct {
+	GroupName string `json:"group_name"`
+	PlayerStats
+}
+
+// IdentityStats combines the statistics of all players linked to an identity.
+type IdentityStats struct {
+	Combined PlayerStats        `json:"combined"`
+	Groups   []GroupPlayerStats `json:"groups"`
+}
+
+// ComputeIdentityStats calculates the statistics of each given player in its
+// own group and combines them. Every group applies its own guest settings.
+func (s *StatsService) ComputeIdentityStats(ctx context.Context, identity models.Identity, players []models.Player) (IdentityStats, error) {
+	result := IdentityStats{
+		Combined: PlayerStats{PlayerID: identity.ID, PlayerName: identity.Name},
+		Groups:   []GroupPlayerStats{},
+	}
+
+	for _, p := range players {
+		stats, err := s.computeStats(ctx, p.GroupName, &p.ID)
+		if err != nil {
+			return IdentityStats{}, err
+		}
+		if len(stats) == 0 {
+			continue
+		}
+		st := stats[0]
+		result.Groups = append(result.Groups, GroupPlayerStats{GroupName: p.GroupName, PlayerStats: st})
+
+		result.Combined.TotalGames += st.TotalGames
+		result.Combined.GamesWon += st.GamesWon
+		result.Combined.GamesLost += st.GamesLost
+		result.Combined.PointsWon += st.PointsWon
+		result.Combined.PointsLost += st.PointsLost
+		result.Combined.GuestGames += st.GuestGames
+	}
+	result.Combined.calculateRates()
+
+	return result, nil
+}
+
+// RebuildGuestFlags recomputes whether each match of a group, or of every
+// group when groupName is empty, was played with guests, which decides how the
+// statistics count it. The merges keep the flags up to date; this repairs the
+// matches of the merges made by older versions. It returns the number of
+// matches whose flag changed.
+func (s *StatsService) RebuildGuestFlags(ctx context.Context, groupName string) (int64, error) {
+	filter := bson.M{}
+	if groupName != "" {
+		filter["group_name"] = groupName
+	}
+	return updateGuestFlags(ctx, s.db, filter)
+}
+
+// updateGuestFlags recomputes the guest flag of the matches selected by
+// filter from their current players, and returns the number of flags changed.
+func updateGuestFlags(ctx context.Context, db *mongo.Database, filter bson.M) (int64, error) {
+	cur, err := db.Collection("matches").Find(ctx, filter, options.Find().SetProjection(bson.M{"has_guests": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var matches []models.Match
+	if err := cur.All(ctx, &matches); err != nil {
+		return 0, err
+	}
+
+	guests := map[primitive.ObjectID]bool{}
+	var changed int64
+	for _, m := range matches {
+		var detail models.MatchDetail
+		if err := db.Collection("matchdetails").FindOne(ctx, bson.M{"match_id": m.ID}).Decode(&detail); err != nil {
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			return changed, err
+		}
+
+		hasGuests := false
+		for _, id := range append(detail.Team1, detail.Team2...) {
+			guest, ok := guests[id]
+			if !ok {
+				var player models.Player
+				err := db.Collection("players").FindOne(ctx, bson.M{"_id": id},
+					options.FindOne().SetProjection(bson.M{"guest": 1})).Decode(&player)
+				if err != nil && err != mongo.ErrNoDocuments {
+					return changed, err
+				}
+				guest = player.Guest
+				guests[id] = guest
+			}
+			hasGuests = hasGuests || guest
+		}
+		if hasGuests == m.HasGuests {
+			continue
+		}
+
+		update := bson.M{"$unset": bson.M{"has_guests": ""}}
+		if hasGuests {
+			update = bson.M{"$set": bson.M{"has_guests": true}}
+		}
+		if _, err := db.Collection("matches").UpdateOne(ctx, bson.M{"_id": m.ID}, update); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// computeStats calculates the statistics of a group, restricted to a single
+// player when only is set.
+func (s *StatsService) computeStats(ctx context.Context, groupName string, only *primitive.ObjectID) ([]PlayerStats, error) {
 	matchesColl := s.db.Collection("matches")
 	detailsColl := s.db.Collection("matchdetails")
 	playersColl := s.db.Collection("players")
 
+	var group models.Group
+	if err := s.db.Collection("groups").FindOne(ctx, bson.M{"name": groupName}).Decode(&group); err != nil {
+		return nil, notFoundIf(err, "group not found")
+	}
+	markGuests := group.Settings.GuestStatsMode() == models.GuestStatsMark
+
 	// Get all completed matches for the group
-	matches, err := matchesColl.Find(ctx, bson.M{
+	filter := bson.M{
 		"group_name": groupName,
 		"status":     "completed",
-	})
+	}
+	if !markGuests {
+		filter["has_guests"] = bson.M{"$ne": true}
+	}
+	matches, err := matchesColl.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -57,34 +191,37 @@ func (s *StatsService) ComputeStats(ctx context.Context, groupName string) ([]Pl
 	// Process each match
 	for matches.Next(ctx) {
 		var match struct {
-			ID primitive.ObjectID `bson:"_id"`
+			ID        primitive.ObjectID `bson:"_id"`
+			HasGuests bool               `bson:"has_guests"`
 		}
 		if err := matches.Decode(&match); err != nil {
 			continue
 		}
 
 		// Get match details
-		var detail struct {
-			Team1      []primitive.ObjectID `bson:"team1"`
-			Team2      []primitive.ObjectID `bson:"team2"`
-			ScoreTeam1 int                  `bson:"score_team1"`
-			ScoreTeam2 int                  `bson:"score_team2"`
-		}
+		var detail models.MatchDetail
 		if err := detailsColl.FindOne(ctx, bson.M{"match_id": match.ID}).Decode(&detail); err != nil {
 			continue
 		}
+		games, winner := matchOutcome(detail)
 
 		// Update stats for Team 1 players
 		for _, playerID := range detail.Team1 {
+			if only != nil && playerID != *only {
+				continue
+			}
 			if _, exists := playerStatsMap[playerID]; !exists {
 				playerStatsMap[playerID] = &PlayerStats{PlayerID: playerID}
 			}
 			stats := playerStatsMap[playerID]
 			stats.TotalGames++
+			if match.HasGuests {
+				stats.GuestGames++
+			}
 			stats.TotalPoints = stats.PointsWon + stats.PointsLost // Update total points
-			stats.PointsWon += detail.ScoreTeam1
-			stats.PointsLost += detail.ScoreTeam2
-			if detail.ScoreTeam1 > detail.ScoreTeam2 {
+			stats.PointsWon += games[0]
+			stats.PointsLost += games[1]
+			if winner == 1 {
 				stats.GamesWon++
 			} else {
 				stats.GamesLost++
@@ -93,15 +230,21 @@ func (s *StatsService) ComputeStats(ctx context.Context, groupName string) ([]Pl
 
 		// Update stats for Team 2 players
 		for _, playerID := range detail.Team2 {
+			if only != nil && playerID != *only {
+				continue
+			}
 			if _, exists := playerStatsMap[playerID]; !exists {
 				playerStatsMap[playerID] = &PlayerStats{PlayerID: playerID}
 			}
 			stats := playerStatsMap[playerID]
 			stats.TotalGames++
+			if match.HasGuests {
+				stats.GuestGames++
+			}
 			stats.TotalPoints = stats.PointsWon + stats.PointsLost // Update total points
-			stats.PointsWon += detail.ScoreTeam2
-			stats.PointsLost += detail.ScoreTeam1
-			if detail.ScoreTeam2 > detail.ScoreTeam1 {
+			stats.PointsWon += games[1]
+			stats.PointsLost += games[0]
+			if winner == 2 {
 				stats.GamesWon++
 			} else {
 				stats.GamesLost++
@@ -110,33 +253,68 @@ func (s *StatsService) ComputeStats(ctx context.Context, groupName string) ([]Pl
 	}
 
 	// Get player names and calculate rates
-	var result []PlayerStats
+	result := []PlayerStats{}
 	for playerID, stats := range playerStatsMap {
 		// Get player name
 		var player struct {
-			Name string `bson:"name"`
+			Name  string `bson:"name"`
+			Guest bool   `bson:"guest"`
 		}
 		if err := playersColl.FindOne(ctx, bson.M{"_id": playerID}).Decode(&player); err != nil {
 			continue
 		}
 
-		// Calculate rates
-		if stats.TotalGames > 0 {
-			stats.GameWinRate = float64(stats.GamesWon) / float64(stats.TotalGames) * 100
-			stats.GameLossRate = float64(stats.GamesLost) / float64(stats.TotalGames) * 100
-		}
-
-		// Calculate total points and rates
-		totalPointsPlayed := stats.PointsWon + stats.PointsLost
-		if totalPointsPlayed > 0 {
-			stats.TotalPoints = totalPointsPlayed
-			stats.PointWinRate = float64(stats.PointsWon) / float64(totalPointsPlayed) * 100
-			stats.PointLossRate = float64(stats.PointsLost) / float64(totalPointsPlayed) * 100
-		}
-
+		stats.calculateRates()
 		stats.PlayerName = player.Name
+		stats.Guest = player.Guest
 		result = append(result, *stats)
 	}
 
 	return result, nil
 }
+
+// calculateRates derives the totals and percentages from the won/lost counters.
+func (stats *PlayerStats) calculateRates() {
+	if stats.TotalGames > 0 {
+		stats.GameWinRate = float64(stats.GamesWon) / float64(stats.TotalGames) * 100
+		stats.GameLossRate = float64(stats.GamesLost) / float64(stats.TotalGames) * 100
+	}
+
+	// Calculate total points and rates
+	totalPointsPlayed := stats.PointsWon + stats.PointsLost
+	if totalPointsPlayed > 0 {
+		stats.TotalPoints = totalPointsPlayed
+		stats.PointWinRate = float64(stats.PointsWon) / float64(totalPointsPlayed) * 100
+		stats.PointLossRate = float64(stats.PointsLost) / float64(totalPointsPlayed) * 100
+	}
+}
+
+// matchOutcome returns the games won by each team of a completed match and
+// the winning team, 0 for a draw. The matches scored set by set are won by
+// the team winning the most sets, whatever the games: 7-6 0-6 7-6 is won by
+// team 1 with 14 games against 18.
+func matchOutcome(detail models.MatchDetail) ([2]int, int) {
+	games := [2]int{detail.ScoreTeam1, detail.ScoreTeam2}
+	score := games
+	if len(detail.Sets) > 0 {
+		games, score = [2]int{}, [2]int{}
+		for _, set := range detail.Sets {
+			games[0] += set[0]
+			games[1] += set[1]
+			switch {
+			case set[0] > set[1]:
+				score[0]++
+			case set[1] > set[0]:
+				score[1]++
+			}
+		}
+	}
+
+	switch {
+	case score[0] > score[1]:
+		return games, 1
+	case score[1] > score[0]:
+		return games, 2
+	}
+	return games, 0
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/p4u/padelfriends/models"
+)
+
+func TestMatchOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		detail models.MatchDetail
+		games  [2]int
+		winner int
+	}{
+		{"score", models.MatchDetail{ScoreTeam1: 6, ScoreTeam2: 4}, [2]int{6, 4}, 1},
+		{"draw", models.MatchDetail{ScoreTeam1: 5, ScoreTeam2: 5}, [2]int{5, 5}, 0},
+		{
+			"sets won with fewer games",
+			models.MatchDetail{ScoreTeam1: 2, ScoreTeam2: 1, Sets: [][2]int{{7, 6}, {0, 6}, {7, 6}}},
+			[2]int{14, 18}, 1,
+		},
+		{
+			"sets lost with more games",
+			models.MatchDetail{ScoreTeam1: 1, ScoreTeam2: 2, Sets: [][2]int{{6, 0}, {6, 7}, {5, 7}}},
+			[2]int{17, 14}, 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			games, winner := matchOutcome(tt.detail)
+			if games != tt.games || winner != tt.winner {
+				t.Errorf("matchOutcome() = %v, %d, want %v, %d", games, winner, tt.games, tt.winner)
+			}
+		})
+	}
+}
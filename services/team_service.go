@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxGeneratorPlayers bounds the number of players the match generator
+// accepts, since it evaluates every group of four.
+const maxGeneratorPlayers = 24
+
+// maxGeneratedMatches bounds the number of matches the generator proposes at
+// once, since each one evaluates every group of four again.
+const maxGeneratedMatches = 50
+
+// Penalties used to rank team splits; lower is better.
+const (
+	levelPenalty      = 10.0 // per level point of difference between team averages
+	sameSidePenalty   = 2.0  // both partners want the same side
+	twoLeftiesPenalty = 1.0  // both partners are left-handed
+	repeatPenalty     = 10.0 // per pair of players who already met in this session
+	imbalancePenalty  = 5.0  // per match of difference in games played
+)
+
+// TeamService suggests balanced teams and matches using the player attributes.
+type TeamService struct {
+	db *mongo.Database
+}
+
+// NewTeamService creates a new TeamService.
+func NewTeamService(db *mongo.Database) *TeamService {
+	return &TeamService{db: db}
+}
+
+// SuggestedPlayer is a player of a suggested team with the side it should play.
+type SuggestedPlayer struct {
+	ID    primitive.ObjectID `json:"id"`
+	Name  string             `json:"name"`
+	Level float64            `json:"level,omitempty"`
+	Side  string             `json:"side,omitempty"`
+}
+
+// TeamSuggestion is a possible split of four players into two teams.
+type TeamSuggestion struct {
+	Team1           []SuggestedPlayer `json:"team1"`
+	Team2           []SuggestedPlayer `json:"team2"`
+	LevelDifference float64           `json:"level_difference"`
+	Penalty         float64           `json:"penalty"`
+}
+
+// PlayerIDs returns the players in match order: team 1 first, then team 2.
+func (t TeamSuggestion) PlayerIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, 4)
+	for _, p := range append(t.Team1, t.Team2...) {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// SuggestTeams returns the three possible splits of four players, the most
+// balanced one first. Balance considers the self-declared levels, preferred
+// sides and handedness.
+func (s *TeamService) SuggestTeams(ctx context.Context, groupName string, playerIDs []primitive.ObjectID) ([]TeamSuggestion, error) {
+	if len(playerIDs) != 4 {
+		return nil, invalid("player_ids", "exactly 4 players required for a match")
+	}
+	if hasDuplicatePlayers(playerIDs) {
+		return nil, invalid("player_ids", "duplicate players are not allowed in a match")
+	}
+
+	players, err := s.loadPlayers(ctx, groupName, playerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	four := [4]models.Player{}
+	for i, id := range playerIDs {
+		four[i] = players[id]
+	}
+	return splitTeams(four), nil
+}
+
+// GenerateMatches proposes count matches among the given players. Each match
+// prefers players who met least in the proposal so far and have played the
+// fewest matches, and uses the most balanced team split.
+func (s *TeamService) GenerateMatches(ctx context.Context, groupName string, playerIDs []primitive.ObjectID, count int) ([]TeamSuggestion, error) {
+	if len(playerIDs) < 4 {
+		return nil, invalid("player_ids", "at least 4 players required")
+	}
+	if len(playerIDs) > maxGeneratorPlayers {
+		return nil, invalid("player_ids", "too many players for the match generator")
+	}
+	if hasDuplicatePlayers(playerIDs) {
+		return nil, invalid("player_ids", "duplicate players are not allowed")
+	}
+	if count < 1 {
+		return nil, invalid("count", "at least one match must be generated")
+	}
+	if count > maxGeneratedMatches {
+		return nil, invalid("count", fmt.Sprintf("at most %d matches can be generated at once", maxGeneratedMatches))
+	}
+
+	players, err := s.loadPlayers(ctx, groupName, playerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	met := make(map[[2]primitive.ObjectID]int)
+	played := make(map[primitive.ObjectID]int)
+	pairKey := func(a, b primitive.ObjectID) [2]primitive.ObjectID {
+		if a.Hex() > b.Hex() {
+			a, b = b, a
+		}
+		return [2]primitive.ObjectID{a, b}
+	}
+
+	result := []TeamSuggestion{}
+	for len(result) < count {
+		var best *TeamSuggestion
+		bestScore := math.Inf(1)
+
+		forEachFour(len(playerIDs), func(idx [4]int) {
+			four := [4]models.Player{}
+			counts := make([]int, 0, 4)
+			score := 0.0
+			for i, j := range idx {
+				four[i] = players[playerIDs[j]]
+				counts = append(counts, played[playerIDs[j]])
+				for _, k := range idx[i+1:] {
+					score += repeatPenalty * float64(met[pairKey(playerIDs[j], playerIDs[k])])
+				}
+			}
+			sort.Ints(counts)
+			score += imbalancePenalty * float64(counts[3]-counts[0])
+
+			split := splitTeams(four)[0]
+			score += split.Penalty
+			if score < bestScore {
+				bestScore = score
+				best = &split
+			}
+		})
+
+		ids := best.PlayerIDs()
+		for i, a := range ids {
+			played[a]++
+			for _, b := range ids[i+1:] {
+				met[pairKey(a, b)]++
+			}
+		}
+		result = append(result, *best)
+	}
+	return result, nil
+}
+
+// loadPlayers fetches the given players, which must all be active players of
+// the group.
+func (s *TeamService) loadPlayers(ctx context.Context, groupName string, playerIDs []primitive.ObjectID) (map[primitive.ObjectID]models.Player, error) {
+	cur, err := s.db.Collection("players").Find(ctx, bson.M{
+		"group_name": groupName,
+		"_id":        bson.M{"$in": playerIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var list []models.Player
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	if len(list) != len(playerIDs) {
+		return nil, invalid("player_ids", "some players were not found in this group")
+	}
+
+	players := make(map[primitive.ObjectID]models.Player, len(list))
+	var inactive []string
+	for _, p := range list {
+		if p.Inactive {
+			inactive = append(inactive, p.ID.Hex())
+		}
+		players[p.ID] = p
+	}
+	if len(inactive) > 0 {
+		return nil, invalid("player_ids", "players are deactivated: "+strings.Join(inactive, ", "))
+	}
+	return players, nil
+}
+
+// forEachFour calls fn with every combination of four indexes below n.
+func forEachFour(n int, fn func([4]int)) {
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			for c := b + 1; c < n; c++ {
+				for d := c + 1; d < n; d++ {
+					fn([4]int{a, b, c, d})
+				}
+			}
+		}
+	}
+}
+
+// splitTeams ranks the three ways of pairing four players.
+func splitTeams(p [4]models.Player) []TeamSuggestion {
+	// Unknown levels count as the average of the known ones
+	known, sum := 0, 0.0
+	for _, pl := range p {
+		if pl.Level > 0 {
+			known++
+			sum += pl.Level
+		}
+	}
+	levelOf := func(pl models.Player) float64 {
+		if pl.Level > 0 {
+			return pl.Level
+		}
+		if known > 0 {
+			return sum / float64(known)
+		}
+		return 0
+	}
+
+	splits := [][4]int{{0, 1, 2, 3}, {0, 2, 1, 3}, {0, 3, 1, 2}}
+	suggestions := make([]TeamSuggestion, 0, len(splits))
+	for _, sp := range splits {
+		a1, a2, b1, b2 := p[sp[0]], p[sp[1]], p[sp[2]], p[sp[3]]
+		diff := math.Abs((levelOf(a1)+levelOf(a2))/2 - (levelOf(b1)+levelOf(b2))/2)
+
+		team1, pen1 := pairSides(a1, a2)
+		team2, pen2 := pairSides(b1, b2)
+		suggestions = append(suggestions, TeamSuggestion{
+			Team1:           team1,
+			Team2:           team2,
+			LevelDifference: math.Round(diff*100) / 100,
+			Penalty:         math.Round((diff*levelPenalty+pen1+pen2)*100) / 100,
+		})
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Penalty < suggestions[j].Penalty
+	})
+	return suggestions
+}
+
+// pairSides assigns the drive and revés sides of a team and returns the
+// penalty of the pairing. Left-handers go to the revés side when possible.
+func pairSides(a, b models.Player) ([]SuggestedPlayer, float64) {
+	penalty := 0.0
+	if a.PreferredSide != "" && a.PreferredSide != models.SideBoth && a.PreferredSide == b.PreferredSide {
+		penalty += sameSidePenalty
+	}
+	if a.Handedness == models.HandLeft && b.Handedness == models.HandLeft {
+		penalty += twoLeftiesPenalty
+	}
+
+	// Put on the revés side whoever fits it better
+	revesScore := func(p models.Player) int {
+		score := 0
+		switch p.PreferredSide {
+		case models.SideReves:
+			score += 2
+		case models.SideDrive:
+			score -= 2
+		}
+		if p.Handedness == models.HandLeft {
+			score++
+		}
+		return score
+	}
+	drive, reves := a, b
+	if revesScore(a) > revesScore(b) {
+		drive, reves = b, a
+	}
+
+	return []SuggestedPlayer{
+		{ID: drive.ID, Name: drive.Name, Level: drive.Level, Side: models.SideDrive},
+		{ID: reves.ID, Name: reves.Name, Level: reves.Level, Side: models.SideReves},
+	}, penalty
+}
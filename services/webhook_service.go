@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/p4u/padelfriends/events"
+	"github.com/p4u/padelfriends/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookEvents lists the event types webhooks can subscribe to.
+var WebhookEvents = []string{
+	events.MatchCreated,
+	events.ResultSubmitted,
+	events.MatchCancelled,
+	events.PlayerAdded,
+}
+
+// maxDeliveriesListed bounds the delivery log returned by ListDeliveries.
+const maxDeliveriesListed = 100
+
+// nonPublicNetworks are the special ranges not covered by the net.IP
+// predicates used by PublicAddress.
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// PublicAddress reports whether ip may be reached by the webhooks: not a
+// loopback, private, link-local, multicast or unspecified address, which
+// would let the groups probe the network of the server.
+func PublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookService manages webhook subscriptions and their persistent delivery
+// queue. A nil *WebhookService queues no deliveries, for the services to use
+// when the webhooks are disabled.
+type WebhookService struct {
+	db           *mongo.Database
+	allowPrivate bool
+}
+
+// NewWebhookService creates a new WebhookService. Webhooks may only target
+// public addresses unless allowPrivate is set, for local testing.
+func NewWebhookService(db *mongo.Database, allowPrivate bool) *WebhookService {
+	return &WebhookService{db: db, allowPrivate: allowPrivate}
+}
+
+// CreateWebhook subscribes a URL to some events of a group, or to all of them
+// when eventTypes is empty. The returned webhook holds the signing secret,
+// which is not shown again.
+func (s *WebhookService) CreateWebhook(ctx context.Context, groupName, target string, eventTypes []string) (models.Webhook, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return models.Webhook{}, invalid("url", "webhook URL must be an absolute http or https URL")
+	}
+	if !s.allowPrivate {
+		if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+			return models.Webhook{}, err
+		}
+	}
+
+	if len(eventTypes) == 0 {
+		eventTypes = WebhookEvents
+	}
+	for _, t := range eventTypes {
+		if !isWebhookEvent(t) {
+			return models.Webhook{}, invalid("events", fmt.Sprintf("unknown event type '%s'", t))
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.Webhook{}, err
+	}
+
+	wh := models.Webhook{
+		GroupName: groupName,
+		URL:       u.String(),
+		Secret:    hex.EncodeToString(secret),
+		Events:    eventTypes,
+		CreatedAt: time.Now(),
+	}
+	res, err := s.db.Collection("webhooks").InsertOne(ctx, wh)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	wh.ID = res.InsertedID.(primitive.ObjectID)
+	return wh, nil
+}
+
+// ListWebhooks returns the webhooks of a group, without their secrets.
+func (s *WebhookService) ListWebhooks(ctx context.Context, groupName string) ([]models.Webhook, error) {
+	cur, err := s.db.Collection("webhooks").Find(ctx,
+		bson.M{"group_name": groupName},
+		options.Find().SetSort(bson.M{"created_at": 1}).SetProjection(bson.M{"secret": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	webhooks := []models.Webhook{}
+	if err := cur.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook and its pending deliveries.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, groupName string, webhookID primitive.ObjectID) error {
+	res, err := s.db.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": webhookID, "group_name": groupName})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return notFound("webhook not found")
+	}
+
+	_, err = s.db.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{
+		"webhook_id": webhookID,
+		"status":     models.DeliveryPending,
+	})
+	return err
+}
+
+// GetWebhook retrieves a webhook, including its secret.
+func (s *WebhookService) GetWebhook(ctx context.Context, webhookID primitive.ObjectID) (models.Webhook, error) {
+	var wh models.Webhook
+	err := s.db.Collection("webhooks").FindOne(ctx, bson.M{"_id": webhookID}).Decode(&wh)
+	if err != nil {
+		return models.Webhook{}, notFoundIf(err, "webhook not found")
+	}
+	return wh, nil
+}
+
+// checkWebhookHost rejects the webhook hosts resolving to a non-public
+// address. The dispatcher checks the addresses again when connecting, since
+// the host may resolve differently by then.
+func checkWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return invalid("url", "cannot resolve the webhook host")
+	}
+	for _, addr := range addrs {
+		if !PublicAddress(addr.IP) {
+			return invalid("url", "webhook URL must target a public address")
+		}
+	}
+	return nil
+}
+
+// enqueue queues a delivery of an event for every webhook of the group
+// subscribed to it. The services call it within the transaction of the
+// change causing the event, so that the event is queued if and only if the
+// change is stored, whatever happens to the server afterwards.
+func (s *WebhookService) enqueue(ctx context.Context, groupName, eventType string, data interface{}) error {
+	if s == nil || !isWebhookEvent(eventType) {
+		return nil
+	}
+
+	cur, err := s.db.Collection("webhooks").Find(ctx, bson.M{
+		"group_name": groupName,
+		"events":     eventType,
+	})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var webhooks []models.Webhook
+	if err := cur.All(ctx, &webhooks); err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	ev := events.Event{
+		ID:        primitive.NewObjectID().Hex(),
+		Type:      eventType,
+		GroupName: groupName,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, 0, len(webhooks))
+	for _, wh := range webhooks {
+		docs = append(docs, models.WebhookDelivery{
+			WebhookID:   wh.ID,
+			GroupName:   ev.GroupName,
+			EventID:     ev.ID,
+			EventType:   ev.Type,
+			Payload:     string(payload),
+			Status:      models.DeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+	_, err = s.db.Collection("webhook_deliveries").InsertMany(ctx, docs)
+	return err
+}
+
+// ClaimDelivery takes the next due delivery, postponing it by lease so that
+// it is retried if the worker dies while delivering it. It returns
+// mongo.ErrNoDocuments when no delivery is due.
+func (s *WebhookService) ClaimDelivery(ctx context.Context, lease time.Duration) (models.WebhookDelivery, error) {
+	now := time.Now()
+	var d models.WebhookDelivery
+	err := s.db.Collection("webhook_deliveries").FindOneAndUpdate(ctx,
+		bson.M{"status": models.DeliveryPending, "next_attempt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt": now.Add(lease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.M{"next_attempt": 1}).
+			SetReturnDocument(options.After),
+	).Decode(&d)
+	return d, err
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A failed attempt is
+// retried at retryAt, or marked as failed when retryAt is zero.
+func (s *WebhookService) RecordAttempt(ctx context.Context, deliveryID primitive.ObjectID, statusCode int, attemptErr error, retryAt time.Time) error {
+	set := bson.M{"last_status_code": statusCode}
+	switch {
+	case attemptErr == nil:
+		set["status"] = models.DeliveryDelivered
+		set["delivered_at"] = time.Now()
+		set["last_error"] = ""
+	case retryAt.IsZero():
+		set["status"] = models.DeliveryFailed
+		set["last_error"] = attemptErr.Error()
+	default:
+		set["next_attempt"] = retryAt
+		set["last_error"] = attemptErr.Error()
+	}
+
+	_, err := s.db.Collection("webhook_deliveries").UpdateOne(ctx,
+		bson.M{"_id": deliveryID},
+		bson.M{"$set": set, "$inc": bson.M{"attempts": 1}},
+	)
+	return err
+}
+
+// ListDeliveries returns the latest deliveries of a group, optionally
+// filtered by webhook and status.
+func (s *WebhookService) ListDeliveries(ctx context.Context, groupName string, webhookID *primitive.ObjectID, status string) ([]models.WebhookDelivery, error) {
+	filter := bson.M{"group_name": groupName}
+	if webhookID != nil {
+		filter["webhook_id"] = *webhookID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cur, err := s.db.Collection("webhook_deliveries").Find(ctx, filter,
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(maxDeliveriesListed),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cur.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RetryDelivery puts a failed delivery back in the queue.
+func (s *WebhookService) RetryDelivery(ctx context.Context, groupName string, deliveryID primitive.ObjectID) error {
+	res, err := s.db.Collection("webhook_deliveries").UpdateOne(ctx,
+		bson.M{"_id": deliveryID, "group_name": groupName, "status": models.DeliveryFailed},
+		bson.M{"$set": bson.M{
+			"status":       models.DeliveryPending,
+			"attempts":     0,
+			"next_attempt": time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return notFound("failed delivery not found")
+	}
+	return nil
+}
+
+func isWebhookEvent(t string) bool {
+	for _, e := range WebhookEvents {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build !embedui
+
+// Package ui bundles the built web application into the binary when built
+// with the embedui tag, after building it into ui/dist. Without the tag the
+// application is served from disk.
+package ui
+
+import "io/fs"
+
+// Embedded reports whether the binary bundles the web application.
+const Embedded = false
+
+// Dist returns the files of the built web application, or nil when the
+// binary was built without the embedui tag.
+func Dist() fs.FS {
+	return nil
+}
@@ -1,15 +1,12 @@
-const CACHE_NAME = 'padel-friends-v1';
+const CACHE_NAME = 'padel-friends-v2';
+// Every entry must exist: a single failed request aborts the installation
 const STATIC_ASSETS = [
   '/',
   '/index.html',
   '/manifest.json',
-  '/icons/icon-72x72.png',
-  '/icons/icon-96x96.png',
-  '/icons/icon-128x128.png',
-  '/icons/icon-144x144.png',
-  '/icons/icon-152x152.png',
+  '/spa-redirect.js',
+  '/favicon.png',
   '/icons/icon-192x192.png',
-  '/icons/icon-384x384.png',
   '/icons/icon-512x512.png',
 ];
 
@@ -127,7 +124,7 @@ self.addEventListener('push', (event) => {
     const options = {
       body: data.body,
       icon: '/icons/icon-192x192.png',
-      badge: '/icons/icon-96x96.png',
+      badge: '/icons/icon-192x192.png',
       vibrate: [100, 50, 100],
       data: {
         url: data.url
@@ -0,0 +1,10 @@
+// Restores the path stored by 404.html on static hosts without SPA fallback
+(function() {
+  const redirect = sessionStorage.getItem('redirect');
+  if (redirect) {
+    sessionStorage.removeItem('redirect');
+    if (redirect !== location.pathname) {
+      history.replaceState(null, null, redirect);
+    }
+  }
+})();
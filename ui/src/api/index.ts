@@ -1,5 +1,5 @@
 import axios from 'axios';
-import type { CreateMatchPayload, CreateBatchMatchesPayload, SubmitScorePayload } from '../types';
+import type { ApiError, CreateMatchPayload, CreateBatchMatchesPayload, SubmitScorePayload, GroupSettings, PlayerAttributes } from '../types';
 
 const api = axios.create({
   baseURL: import.meta.env.VITE_API_URL,
@@ -15,7 +15,10 @@ api.interceptors.response.use(
   error => {
     let errorMessage = 'An unexpected error occurred';
     
-    if (error.response) {
+    if (error.response?.data?.error) {
+      // JSON error envelope of the API, see ApiError
+      errorMessage = (error.response.data as ApiError).error;
+    } else if (error.response) {
       switch (error.response.status) {
         case 401:
           errorMessage = 'Unauthorized access';
@@ -56,11 +59,53 @@ export const groupApi = {
   authenticate: (name: string, password: string) =>
     api.post(`/group/${name}/authenticate`, { password }),
   
-  addPlayer: (groupId: string, password: string, name: string) =>
-    api.post(`/group/${groupId}/players`, { name }, { params: { password } }),
+  addPlayer: (groupId: string, password: string, name: string, guest: boolean = false) =>
+    api.post(`/group/${groupId}/players`, { name, guest }, { params: { password } }),
+
+  updateSettings: (groupId: string, password: string, settings: GroupSettings) =>
+    api.put(`/group/${groupId}/settings`, settings, { params: { password } }),
   
-  getPlayers: (groupId: string) =>
-    api.get(`/group/${groupId}/players`, { params: addStoredPassword() }),
+  getPlayers: (groupId: string, all: boolean = false) =>
+    api.get(`/group/${groupId}/players`, { params: addStoredPassword(all ? { all } : {}) }),
+
+  renamePlayer: (groupId: string, password: string, playerId: string, name: string) =>
+    api.put(`/group/${groupId}/players/${playerId}`, { name }, { params: { password } }),
+
+  setPlayerActive: (groupId: string, password: string, playerId: string, active: boolean) =>
+    api.post(`/group/${groupId}/players/${playerId}/${active ? 'activate' : 'deactivate'}`,
+      {},
+      { params: { password } }
+    ),
+
+  updatePlayerAttributes: (groupId: string, password: string, playerId: string, attributes: PlayerAttributes) =>
+    api.put(`/group/${groupId}/players/${playerId}/attributes`, attributes, { params: { password } }),
+
+  uploadAvatar: (groupId: string, password: string, playerId: string, file: File) => {
+    const form = new FormData();
+    form.append('avatar', file);
+    return api.post(`/group/${groupId}/players/${playerId}/avatar`, form, {
+      params: { password },
+      headers: { 'Content-Type': 'multipart/form-data' }
+    });
+  },
+
+  avatarUrl: (groupId: string, playerId: string) =>
+    `${import.meta.env.VITE_API_URL}/group/${groupId}/players/${playerId}/avatar`,
+
+  suggestTeams: (groupId: string, playerIds: string[]) =>
+    api.post(`/group/${groupId}/teams/suggest`, { player_ids: playerIds }),
+
+  generateMatches: (groupId: string, playerIds: string[], count: number) =>
+    api.post(`/group/${groupId}/matches/generate`, { player_ids: playerIds, count }),
+
+  deletePlayer: (groupId: string, password: string, playerId: string) =>
+    api.delete(`/group/${groupId}/players/${playerId}`, { params: { password } }),
+
+  mergePlayers: (groupId: string, password: string, duplicateId: string, canonicalId: string) =>
+    api.post(`/group/${groupId}/players/${duplicateId}/merge`,
+      { into: canonicalId },
+      { params: { password } }
+    ),
   
   createMatch: (groupId: string, password: string, playerIds: string[]) =>
     api.post(`/group/${groupId}/matches`, 
@@ -68,11 +113,21 @@ export const groupApi = {
       { params: { password } }
     ),
 
-  createBatchMatches: (groupId: string, password: string, matches: string[][]) =>
-    api.post(`/group/${groupId}/matches/batch`,
-      { matches },
-      { params: { password } }
-    ),
+  // Retries on network errors with the same Idempotency-Key, so that a
+  // request that reached the server is not applied twice
+  createBatchMatches: async (groupId: string, password: string, matches: string[][]) => {
+    const idempotencyKey = crypto.randomUUID();
+    for (let attempt = 1; ; attempt++) {
+      try {
+        return await api.post(`/group/${groupId}/matches/batch`,
+          { matches },
+          { params: { password }, headers: { 'Idempotency-Key': idempotencyKey } }
+        );
+      } catch (error) {
+        if (attempt >= 3 || error !== 'Network error - please check your connection') throw error;
+      }
+    }
+  },
   
   cancelMatch: (groupId: string, matchId: string, password: string) =>
     api.post(`/group/${groupId}/matches/${matchId}/cancel`,
@@ -80,15 +135,23 @@ export const groupApi = {
       { params: { password } }
     ),
 
-  submitResults: (groupId: string, matchId: string, password: string, scoreTeam1: number, scoreTeam2: number) =>
+  // With the version of the match shown to the user, the submission fails
+  // if someone else updated the match meanwhile
+  submitResults: (groupId: string, matchId: string, password: string, scoreTeam1: number, scoreTeam2: number, version?: number) =>
     api.post(`/group/${groupId}/matches/${matchId}/results`, 
       { 
         score_team1: parseInt(String(scoreTeam1)), 
         score_team2: parseInt(String(scoreTeam2)) 
       }, 
-      { params: { password } }
+      {
+        params: { password },
+        headers: version === undefined ? {} : { 'If-Match': `"${version}"` }
+      }
     ),
   
+  disputeResult: (groupId: string, matchId: string, password: string, reason: string) =>
+    api.post(`/group/${groupId}/matches/${matchId}/dispute`, { reason }, { params: { password } }),
+
   getRecentMatches: (groupId: string) =>
     api.get(`/group/${groupId}/matches`, { 
       params: addStoredPassword({
@@ -112,4 +175,44 @@ export const groupApi = {
       params: addStoredPassword(),
       responseType: 'blob' 
     }),
+
+  createBotLinkToken: (groupId: string, password: string) =>
+    api.post(`/group/${groupId}/bot/link-token`, {}, { params: { password } }),
+};
+
+// Opens the Server-Sent Events stream of a group; the browser resumes
+// automatically from the last received event after a reconnection.
+export const groupEvents = (groupId: string): EventSource =>
+  new EventSource(`${import.meta.env.VITE_API_URL}/group/${groupId}/events`);
+
+// Opens the live scoring WebSocket of a pending match. Without the group
+// password the connection only follows the score.
+export const liveScore = (groupId: string, matchId: string, password?: string): WebSocket => {
+  const url = new URL(`${import.meta.env.VITE_API_URL}/group/${groupId}/matches/${matchId}/live`, window.location.href);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  if (password) url.searchParams.set('password', password);
+  return new WebSocket(url);
+};
+
+export const identityApi = {
+  create: (name: string, password: string) =>
+    api.post('/identity', { name, password }),
+
+  get: (identityId: string, password: string) =>
+    api.get(`/identity/${identityId}`, { params: { password } }),
+
+  getMatches: (identityId: string, password: string) =>
+    api.get(`/identity/${identityId}/matches`, { params: { password } }),
+
+  getStatistics: (identityId: string, password: string) =>
+    api.get(`/identity/${identityId}/statistics`, { params: { password } }),
+
+  unlinkPlayer: (identityId: string, password: string, playerId: string) =>
+    api.delete(`/identity/${identityId}/players/${playerId}`, { params: { password } }),
+
+  linkPlayer: (groupId: string, groupPassword: string, playerId: string, identityId: string, identityPassword: string) =>
+    api.post(`/group/${groupId}/players/${playerId}/link`,
+      { identity_id: identityId, identity_password: identityPassword },
+      { params: { password: groupPassword } }
+    ),
 };
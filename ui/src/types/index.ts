@@ -1,7 +1,13 @@
+export interface GroupSettings {
+  guest_stats: 'exclude' | 'mark';
+}
+
 export interface Group {
   id: string;
   name: string;
+  slug?: string;
   created_at: string;
+  settings?: GroupSettings;
 }
 
 export interface Player {
@@ -9,11 +15,26 @@ export interface Player {
   name: string;
   group_id: string;
   created_at: string;
+  inactive?: boolean;
+  guest?: boolean;
+  avatar?: string;
+  preferred_side?: 'drive' | 'reves' | 'both';
+  handedness?: 'right' | 'left';
+  level?: number;
+  contact?: PlayerContact;
+}
+
+export interface PlayerContact {
+  email?: string;
+  phone?: string;
 }
 
+export type PlayerAttributes = Pick<Player, 'preferred_side' | 'handedness' | 'level' | 'contact'>;
+
 export interface PlayerInfo {
   id: string;
   name: string;
+  guest?: boolean;
 }
 
 export interface Match {
@@ -25,6 +46,9 @@ export interface Match {
   score_team1?: number;
   score_team2?: number;
   status: 'pending' | 'completed' | 'cancelled';
+  has_guests?: boolean;
+  disputed?: boolean;
+  version: number;
 }
 
 export interface Statistics {
@@ -44,6 +68,9 @@ export interface Statistics {
   points_lost: number;
   point_win_rate: number;
   point_loss_rate: number;
+
+  guest: boolean;
+  guest_games: number;
 }
 
 export interface CreateMatchPayload {
@@ -66,3 +93,10 @@ export interface IndexSignature {
 
 // Extend Statistics to include index signature
 export interface StatisticsWithIndex extends Statistics, IndexSignature {}
+
+// Error response of the API
+export interface ApiError {
+  error: string;
+  code: 'bad_request' | 'validation_failed' | 'unauthorized' | 'forbidden' | 'not_found' | 'conflict' | 'internal_error';
+  details?: { field: string; message: string }[];
+}
@@ -0,0 +1,207 @@
+// Package webhooks delivers group events to the subscribed webhooks. The
+// services store the deliveries in a persistent queue together with the
+// changes causing them; the dispatcher posts them with an HMAC signature,
+// retrying failed deliveries with exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/p4u/padelfriends/models"
+	"github.com/p4u/padelfriends/services"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Headers set on every delivery.
+const (
+	HeaderSignature = "X-Padelfriends-Signature"
+	HeaderTimestamp = "X-Padelfriends-Timestamp"
+	HeaderEvent     = "X-Padelfriends-Event"
+	HeaderDelivery  = "X-Padelfriends-Delivery"
+)
+
+// Options tunes the dispatcher.
+type Options struct {
+	MaxAttempts  int           // attempts before a delivery is marked as failed
+	BaseBackoff  time.Duration // delay before the first retry, doubled at each attempt
+	MaxBackoff   time.Duration // upper bound of the retry delay
+	PollInterval time.Duration // how often the queue is checked for due deliveries
+	Timeout      time.Duration // timeout of a single delivery request
+
+	// AllowPrivate delivers to loopback, private and link-local addresses
+	// too, which are refused by default. Only meant for local testing.
+	AllowPrivate bool
+}
+
+// DefaultOptions retries during roughly a day before giving up.
+var DefaultOptions = Options{
+	MaxAttempts:  10,
+	BaseBackoff:  30 * time.Second,
+	MaxBackoff:   6 * time.Hour,
+	PollInterval: 2 * time.Second,
+	Timeout:      10 * time.Second,
+}
+
+// Dispatcher delivers the queued events.
+type Dispatcher struct {
+	service *services.WebhookService
+	client  *http.Client
+	opts    Options
+}
+
+// NewDispatcher creates a Dispatcher using the given options. Redirects are
+// not followed, so that a webhook cannot send the deliveries elsewhere.
+func NewDispatcher(service *services.WebhookService, opts Options) *Dispatcher {
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !opts.AllowPrivate {
+		// Through a proxy, only the address of the proxy could be checked
+		transport.Proxy = nil
+		dialer.Control = publicOnly
+	}
+	transport.DialContext = dialer.DialContext
+
+	return &Dispatcher{
+		service: service,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		opts: opts,
+	}
+}
+
+// publicOnly refuses to connect to the non-public addresses. It runs once the
+// host is resolved, so that a webhook accepted at creation cannot be pointed
+// at the internal network afterwards through its DNS records.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !services.PublicAddress(ip) {
+		return fmt.Errorf("webhook target %s is not a public address", host)
+	}
+	return nil
+}
+
+// Run delivers the queue until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+// deliverDue sends every delivery that is due.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		delivery, err := d.service.ClaimDelivery(ctx, d.opts.Timeout*2)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "webhooks: cannot read delivery queue", "error", err)
+			return
+		}
+		d.attempt(ctx, delivery)
+	}
+}
+
+// attempt delivers once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) {
+	wh, err := d.service.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		// The webhook was removed: nothing to retry
+		d.service.RecordAttempt(ctx, delivery.ID, 0, err, time.Time{})
+		return
+	}
+
+	status, err := d.post(ctx, wh, delivery)
+
+	var retryAt time.Time
+	if err != nil && delivery.Attempts+1 < d.opts.MaxAttempts {
+		retryAt = time.Now().Add(d.backoff(delivery.Attempts + 1))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "webhooks: delivery failed", "delivery_id", delivery.ID.Hex(),
+			"webhook_id", wh.ID.Hex(), "attempt", delivery.Attempts+1, "error", err)
+	}
+	if rerr := d.service.RecordAttempt(ctx, delivery.ID, status, err, retryAt); rerr != nil {
+		slog.ErrorContext(ctx, "webhooks: cannot record delivery", "delivery_id", delivery.ID.Hex(), "error", rerr)
+	}
+}
+
+// post sends the payload and returns the response status code. Any non-2xx
+// status is an error.
+func (d *Dispatcher) post(ctx context.Context, wh models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "padelfriends-webhooks")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID.Hex())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(wh.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the given retry.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.opts.BaseBackoff
+	for i := 1; i < attempt && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay
+}
+
+// Sign computes the hex HMAC-SHA256 of "timestamp.body" with the webhook
+// secret. Receivers recompute it to check the X-Padelfriends-Signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}